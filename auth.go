@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"golang.org/x/term"
+)
+
+// Tokens obtained by logging in are renewed by logging in again once less than
+// this much of their TTL remains
+const tokenRenewMargin = 30 * time.Second
+
+// Authenticates the operator against a cluster using a Vault auth method
+type authenticator interface {
+	login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error)
+}
+
+// Authenticates with the AppRole auth method. The secret ID is read from its
+// file on every login, so that it may be rotated externally.
+type appRoleAuth struct {
+	mount        string
+	roleID       string
+	secretID     string
+	secretIDFile string
+}
+
+func (a appRoleAuth) login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	secretID := a.secretID
+	if a.secretIDFile != "" {
+		b, err := os.ReadFile(a.secretIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading secret ID file: %w", err)
+		}
+		secretID = strings.TrimSpace(string(b))
+	}
+	resp, err := client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
+		RoleId:   a.roleID,
+		SecretId: secretID,
+	}, vault.WithMountPath(a.mount))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// Authenticates with the Kubernetes auth method using a service account JWT
+type kubernetesAuth struct {
+	mount   string
+	role    string
+	jwtFile string
+}
+
+func (a kubernetesAuth) login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	jwt, err := os.ReadFile(a.jwtFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %w", err)
+	}
+	resp, err := client.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
+		Jwt:  strings.TrimSpace(string(jwt)),
+		Role: a.role,
+	}, vault.WithMountPath(a.mount))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// Authenticates with the TLS certificate auth method. The client certificate is
+// presented by the client's TLS configuration.
+type certAuth struct {
+	mount string
+	name  string
+}
+
+func (a certAuth) login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	resp, err := client.Auth.CertLogin(ctx, schema.CertLoginRequest{
+		Name: a.name,
+	}, vault.WithMountPath(a.mount))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// Authenticates with the userpass auth method
+type userpassAuth struct {
+	mount    string
+	username string
+	password string
+}
+
+func (a userpassAuth) login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	resp, err := client.Auth.UserpassLogin(ctx, a.username, schema.UserpassLoginRequest{
+		Password: a.password,
+	}, vault.WithMountPath(a.mount))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// A token obtained by logging in to a cluster
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// Tokens obtained by logging in, keyed by cluster address. The cache is shared
+// by every copy of the client configuration, so that repeated discovery does not
+// log in again while a token remains valid.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+// Build the authenticator for the configured auth method, or nil when the
+// operation batch token is used directly
+func (c *ClientConfig) buildAuthenticator() (authenticator, error) {
+	a := c.Auth
+	mount := a.Mount
+	if mount == "" {
+		mount = a.Method
+	}
+
+	switch a.Method {
+	case "token":
+		return nil, nil
+	case "approle":
+		secretID := os.Getenv("VAULT_FM_SECRET_ID")
+		if a.RoleID == "" || (secretID == "" && a.SecretIDFile == "") {
+			return nil, fmt.Errorf("approle auth requires -authRoleId and either -authSecretIdFile or $VAULT_FM_SECRET_ID")
+		}
+		return appRoleAuth{mount: mount, roleID: a.RoleID, secretID: secretID, secretIDFile: a.SecretIDFile}, nil
+	case "kubernetes":
+		if a.Role == "" {
+			return nil, fmt.Errorf("kubernetes auth requires -authRole")
+		}
+		return kubernetesAuth{mount: mount, role: a.Role, jwtFile: a.JwtFile}, nil
+	case "cert":
+		if a.CertFile == "" || a.KeyFile == "" {
+			return nil, fmt.Errorf("cert auth requires -authCertFile and -authKeyFile")
+		}
+		return certAuth{mount: mount, name: a.Role}, nil
+	case "userpass":
+		if a.Username == "" {
+			return nil, fmt.Errorf("userpass auth requires -authUsername")
+		}
+		password := os.Getenv("VAULT_FM_PASSWORD")
+		if password == "" {
+			if c.Unattended {
+				return nil, fmt.Errorf("userpass auth requires $VAULT_FM_PASSWORD when running unattended")
+			}
+			fmt.Printf("Password for %s: ", a.Username)
+			b, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println()
+			if err != nil {
+				return nil, fmt.Errorf("error reading password: %w", err)
+			}
+			password = string(b)
+		}
+		return userpassAuth{mount: mount, username: a.Username, password: password}, nil
+	default:
+		return nil, fmt.Errorf("unsupported auth method: %s", a.Method)
+	}
+}
+
+// Return the name of the cluster at addr, if it has been discovered
+func (c *ConfigData) clusterName(addr string) string {
+	if cluster, _ := c.clusterByAddr(addr); cluster != nil && cluster.Name != "" {
+		return cluster.Name
+	}
+	for _, snapshot := range c.Topology.Clusters {
+		if snapshot.Addr == addr {
+			return snapshot.Name
+		}
+	}
+	return ""
+}
+
+// Return the token used for requests to the cluster at addr
+func (c *ConfigData) tokenFor(addr string) (string, error) {
+	return c.tokenForCluster(addr, c.clusterName(addr))
+}
+
+// Return the token used for requests to the cluster with the given address and
+// name. Without an auth method, this is the operation token configured for the
+// cluster's address or name, falling back to the shared operation batch token.
+// With an auth method configured, a cached token is returned while it remains
+// valid, and a new one is obtained by logging in otherwise.
+func (c *ConfigData) tokenForCluster(addr string, name string) (string, error) {
+	if c.ClientConfig.authenticator == nil {
+		if token, ok := c.ClientConfig.OpBatchTokens[addr]; ok {
+			return token, nil
+		}
+		if token, ok := c.ClientConfig.OpBatchTokens[name]; ok && name != "" {
+			return token, nil
+		}
+		return c.ClientConfig.OpBatchToken, nil
+	}
+
+	cache := c.ClientConfig.tokens
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cached, ok := cache.tokens[addr]; ok && (cached.expires.IsZero() || time.Until(cached.expires) > tokenRenewMargin) {
+		return cached.token, nil
+	}
+
+	client, err := c.newClient(addr)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	auth, err := c.ClientConfig.authenticator.login(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("%s login to %s failed: %w", c.ClientConfig.Auth.Method, addr, err)
+	}
+	if auth == nil || auth.ClientToken == "" {
+		return "", fmt.Errorf("%s login to %s did not return a token", c.ClientConfig.Auth.Method, addr)
+	}
+
+	policies := strings.Join(auth.Policies, ",")
+	if !strings.Contains(","+policies+",", ","+handlerPolicyName+",") {
+		log.Printf("WARN: token issued by %s login to %s does not have the %s policy (policies: %s)", c.ClientConfig.Auth.Method, addr, handlerPolicyName, policies)
+	}
+
+	cached := cachedToken{token: auth.ClientToken}
+	if auth.LeaseDuration > 0 {
+		cached.expires = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+	cache.tokens[addr] = cached
+	log.Printf("Authenticated to %s with %s auth", addr, c.ClientConfig.Auth.Method)
+	return cached.token, nil
+}
+
+// Return the token passed as the DR operation token. This must be the operation
+// batch token: a token obtained by logging in with an auth method is not a DR
+// operation batch token, and cannot be obtained from a DR secondary anyway.
+func (c *ConfigData) drOperationToken() (string, error) {
+	if c.ClientConfig.OpBatchToken == "" {
+		return "", fmt.Errorf("DR operations on a secondary require a DR operation batch token - set -opBatchToken, or generate one with the recovery flow")
+	}
+	return c.ClientConfig.OpBatchToken, nil
+}
+
+// Operation tokens keyed by cluster address or name, set from a comma-separated
+// list of key=token pairs
+type tokenMap map[string]string
+
+func (m *tokenMap) String() string {
+	var keys []string
+	for key := range *m {
+		keys = append(keys, key+"="+redacted)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (m *tokenMap) Set(value string) error {
+	if *m == nil {
+		*m = tokenMap{}
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, token, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || token == "" {
+			return fmt.Errorf("invalid cluster token %q - expected <address or cluster name>=<token>", pair)
+		}
+		(*m)[key] = token
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenMapSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    tokenMap
+		wantErr bool
+	}{
+		{
+			name:   "single pair",
+			values: []string{"https://b:8200=s.b"},
+			want:   tokenMap{"https://b:8200": "s.b"},
+		},
+		{
+			name:   "addresses and cluster names",
+			values: []string{"https://b:8200=s.b,perf-east=s.c"},
+			want:   tokenMap{"https://b:8200": "s.b", "perf-east": "s.c"},
+		},
+		{
+			name:   "repeated flags accumulate and override",
+			values: []string{"perf-east=s.c,perf-west=s.d", "perf-east=s.e"},
+			want:   tokenMap{"perf-east": "s.e", "perf-west": "s.d"},
+		},
+		{
+			name:   "token containing an equals sign",
+			values: []string{"perf-east=s.c=="},
+			want:   tokenMap{"perf-east": "s.c=="},
+		},
+		{
+			name:    "missing separator",
+			values:  []string{"perf-east"},
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			values:  []string{"=s.c"},
+			wantErr: true,
+		},
+		{
+			name:    "empty token",
+			values:  []string{"perf-east="},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m tokenMap
+			var err error
+			for _, value := range tt.values {
+				if err = m.Set(value); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("Set() = %v, want %v", m, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenMapStringRedacts(t *testing.T) {
+	m := tokenMap{"perf-west": "s.d", "perf-east": "s.c"}
+	want := "perf-east=" + redacted + ",perf-west=" + redacted
+	if got := m.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The DR and performance replication status of a single cluster, as reported by
+// /sys/replication/status
+type combinedSnapshot struct {
+	Addr        string
+	Dr          DrConfigBase
+	Performance PrConfigBase
+}
+
+// A set of clusters that share a replication relationship in a single mode,
+// evaluated as one unit of a combined run
+type replicationGroup struct {
+	Mode  string
+	Addrs []string
+	// the group contains the performance primary, so performance secondaries
+	// must be re-pointed if it is failed over
+	PerformancePrimary bool
+}
+
+// Discover the DR and performance replication status of the cluster at each
+// address. This only reads cluster state.
+func (c *ConfigData) getCombinedStatus(addrs []string) ([]combinedSnapshot, error) {
+	client := c.getHttpClient()
+
+	var snapshots []combinedSnapshot
+	for _, addr := range addrs {
+		var repStatus struct {
+			Data struct {
+				Dr          DrConfigBase `json:"dr"`
+				Performance PrConfigBase `json:"performance"`
+			} `json:"data"`
+		}
+		status, err := getJson(client, addr, replicationPath+"status", "", &repStatus)
+		if err != nil || status != 200 {
+			return nil, fmt.Errorf("replication status discovery failed for %s: status %d: %v", addr, status, err)
+		}
+		snapshots = append(snapshots, combinedSnapshot{
+			Addr:        addr,
+			Dr:          repStatus.Data.Dr,
+			Performance: repStatus.Data.Performance,
+		})
+	}
+	return snapshots, nil
+}
+
+// Report whether a replication mode is enabled
+func replicationEnabled(mode string) bool {
+	return mode == "primary" || mode == "secondary"
+}
+
+// Partition the discovered clusters into DR replication groups, keyed by DR
+// cluster ID, followed by a single performance replication group. The DR group
+// containing the performance primary is ordered first. DR secondaries are left
+// out of the performance group, as they only mirror their DR primary. The
+// unreachable addresses are added to every group, so that a group whose primary
+// is down is evaluated as such.
+func combinedGroups(snapshots []combinedSnapshot, unreachable []string) []replicationGroup {
+	var drGroups []replicationGroup
+	drIndex := map[string]int{}
+	performance := replicationGroup{Mode: "performance"}
+
+	for _, snapshot := range snapshots {
+		if replicationEnabled(snapshot.Dr.Mode) {
+			i, ok := drIndex[snapshot.Dr.ClusterID]
+			if !ok {
+				i = len(drGroups)
+				drIndex[snapshot.Dr.ClusterID] = i
+				drGroups = append(drGroups, replicationGroup{Mode: "dr"})
+			}
+			drGroups[i].Addrs = append(drGroups[i].Addrs, snapshot.Addr)
+			if snapshot.Performance.Mode == "primary" {
+				drGroups[i].PerformancePrimary = true
+			}
+		}
+		if replicationEnabled(snapshot.Performance.Mode) && snapshot.Dr.Mode != "secondary" {
+			performance.Addrs = append(performance.Addrs, snapshot.Addr)
+		}
+	}
+
+	var groups []replicationGroup
+	for _, group := range drGroups {
+		if group.PerformancePrimary {
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range drGroups {
+		if !group.PerformancePrimary {
+			groups = append(groups, group)
+		}
+	}
+	if len(performance.Addrs) > 0 {
+		groups = append(groups, performance)
+	}
+
+	for i := range groups {
+		groups[i].Addrs = append(groups[i].Addrs, unreachable...)
+	}
+	return groups
+}
+
+// Return a copy of the configuration for evaluating a single replication group
+func (c *ConfigData) groupConfig(group replicationGroup) ConfigData {
+	g := c.fresh()
+	g.ClientConfig.Mode = group.Mode
+	g.ClientConfig.ConfiguredAddrs = strings.Join(group.Addrs, ",")
+	return g
+}
+
+// Evaluate DR and performance replication together. Each DR group is evaluated
+// and acted on first, starting with the group containing the performance
+// primary. Performance replication is then rediscovered, so that it reflects
+// any DR promotion. When the performance primary was failed over to its DR
+// secondary, each performance secondary is re-pointed at the promoted cluster.
+func (c *ConfigData) evaluateCombined() error {
+	err := c.ClientConfig.verifyAddrs()
+	if err != nil {
+		return err
+	}
+	snapshots, err := c.getCombinedStatus(c.ClientConfig.VerifiedAddrs)
+	if err != nil {
+		return fmt.Errorf("error getting replication status: %w", err)
+	}
+
+	verified := map[string]bool{}
+	for _, addr := range c.ClientConfig.VerifiedAddrs {
+		verified[addr] = true
+	}
+	var unreachable []string
+	for _, addr := range strings.Split(c.ClientConfig.ConfiguredAddrs, ",") {
+		if !verified[addr] {
+			unreachable = append(unreachable, addr)
+		}
+	}
+
+	// the DR secondary promoted in place of the performance primary, if any,
+	// and the clusters of the DR group it was promoted in
+	var promoted string
+	replaced := map[string]bool{}
+	for _, group := range combinedGroups(snapshots, unreachable) {
+		if group.Mode == "performance" && promoted != "" && !c.ClientConfig.DryRun {
+			addrs := []string{promoted}
+			for _, addr := range group.Addrs {
+				if !replaced[addr] {
+					addrs = append(addrs, addr)
+				}
+			}
+			group.Addrs = addrs
+		}
+		if len(group.Addrs) < 2 {
+			log.Printf("Skipping %s replication group with a single cluster: %s", group.Mode, strings.Join(group.Addrs, ","))
+			continue
+		}
+		log.Printf("Evaluating %s replication for %s", group.Mode, strings.Join(group.Addrs, ","))
+
+		g := c.groupConfig(group)
+		err := g.refresh()
+		if err != nil {
+			return fmt.Errorf("%s replication: %w", group.Mode, err)
+		}
+
+		if group.Mode == "performance" && promoted != "" {
+			err = g.repointPerformanceSecondaries(promoted)
+			if err != nil {
+				return fmt.Errorf("performance replication: %w", err)
+			}
+			continue
+		}
+
+		g.confirmReplication()
+		s := g.selectScenario()
+		if c.ClientConfig.DryRun {
+			fmt.Printf("\n%s replication (%s):\n", group.Mode, strings.Join(group.Addrs, ","))
+		}
+		err = g.act(s)
+		if err != nil {
+			return fmt.Errorf("%s replication: %w", group.Mode, err)
+		}
+		if group.PerformancePrimary && s.promotes() {
+			promoted = g.SecondaryCluster.Addr
+			if s.Name == "dual-secondary" {
+				promoted = g.PrimaryCluster.Addr
+			}
+			for _, addr := range group.Addrs {
+				replaced[addr] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// Re-point each performance secondary at the cluster promoted in place of the
+// performance primary. In dry-run mode the promotion has not happened yet, so
+// the steps are planned against the promoted cluster's address.
+func (c *ConfigData) repointPerformanceSecondaries(promoted string) error {
+	if c.ClientConfig.DryRun {
+		fmt.Printf("\nperformance replication (%s):\n", c.ClientConfig.ConfiguredAddrs)
+		fmt.Printf("Performance secondaries would be re-pointed at %s once it is promoted\n", promoted)
+		c.PrimaryCluster = ClusterData{Addr: promoted}
+	} else if c.PrimaryCluster.Addr != promoted || !c.PrimaryCluster.Leader {
+		return fmt.Errorf("expected %s to be the performance primary after DR promotion", promoted)
+	}
+
+	log.Printf("Re-pointing performance secondaries at %s", promoted)
+	return c.execute(c.repointSteps(&c.PrimaryCluster, c.secondaries()))
+}
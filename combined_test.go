@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCombinedGroups(t *testing.T) {
+	snapshot := func(addr string, drMode string, drID string, prMode string) combinedSnapshot {
+		return combinedSnapshot{
+			Addr:        addr,
+			Dr:          DrConfigBase{ClusterID: drID, Mode: drMode},
+			Performance: PrConfigBase{Mode: prMode},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		snapshots   []combinedSnapshot
+		unreachable []string
+		want        []replicationGroup
+	}{
+		{
+			name: "DR only",
+			snapshots: []combinedSnapshot{
+				snapshot("https://a:8200", "primary", "dr1", "disabled"),
+				snapshot("https://b:8200", "secondary", "dr1", "disabled"),
+			},
+			want: []replicationGroup{
+				{Mode: "dr", Addrs: []string{"https://a:8200", "https://b:8200"}},
+			},
+		},
+		{
+			name: "performance primary's DR group first, DR secondaries left out of performance",
+			snapshots: []combinedSnapshot{
+				snapshot("https://c:8200", "primary", "dr2", "secondary"),
+				snapshot("https://d:8200", "secondary", "dr2", "disabled"),
+				snapshot("https://a:8200", "primary", "dr1", "primary"),
+				snapshot("https://b:8200", "secondary", "dr1", "disabled"),
+			},
+			want: []replicationGroup{
+				{Mode: "dr", Addrs: []string{"https://a:8200", "https://b:8200"}, PerformancePrimary: true},
+				{Mode: "dr", Addrs: []string{"https://c:8200", "https://d:8200"}},
+				{Mode: "performance", Addrs: []string{"https://c:8200", "https://a:8200"}},
+			},
+		},
+		{
+			name: "unreachable addresses added to every group",
+			snapshots: []combinedSnapshot{
+				snapshot("https://b:8200", "secondary", "dr1", "disabled"),
+				snapshot("https://c:8200", "disabled", "", "secondary"),
+			},
+			unreachable: []string{"https://a:8200"},
+			want: []replicationGroup{
+				{Mode: "dr", Addrs: []string{"https://b:8200", "https://a:8200"}},
+				{Mode: "performance", Addrs: []string{"https://c:8200", "https://a:8200"}},
+			},
+		},
+		{
+			name: "no replication enabled",
+			snapshots: []combinedSnapshot{
+				snapshot("https://a:8200", "disabled", "", "disabled"),
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := combinedGroups(tt.snapshots, tt.unreachable)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("combinedGroups() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
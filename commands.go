@@ -0,0 +1,358 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// A subcommand of the operator along with the function that runs it
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
+
+// Flags that may be left empty when a subcommand is invoked
+var optionalFlags = map[string]bool{
+	"opBatchToken":         true,
+	"witnesses":            true,
+	"witnessToken":         true,
+	"witnessCertFile":      true,
+	"witnessKeyFile":       true,
+	"witnessCaFile":        true,
+	"apiToken":             true,
+	"tlsCertFile":          true,
+	"tlsKeyFile":           true,
+	"tlsClientCAFile":      true,
+	"metricsAddr":          true,
+	"mode":                 true,
+	"recoveryPgpKey":       true,
+	"opBatchTokens":        true,
+	"authMount":            true,
+	"authRole":             true,
+	"authRoleId":           true,
+	"authSecretIdFile":     true,
+	"authCertFile":         true,
+	"authKeyFile":          true,
+	"authUsername":         true,
+	"ttl":                  true,
+	"privilegedTokenFile":  true,
+	"privilegedTokenFiles": true,
+	"target":               true,
+	"allowDataLoss":        true,
+}
+
+// Report whether a subcommand supports the combined replication mode
+func supportsCombined(command string) bool {
+	return command == "evaluate" || command == "plan"
+}
+
+// Return the list of supported subcommands
+func getCommands() []command {
+	return []command{
+		{"evaluate", "Discover the topology and take the action appropriate for the current scenario (default)", runEvaluate},
+		{"status", "Discover and report the replication topology without taking action", runStatus},
+		{"plan", "Discover the topology and print the steps that evaluate would take, without taking action", runPlan},
+		{"failover", "Demote the primary cluster and promote the secondary cluster", runFailover},
+		{"promote", "Promote the secondary cluster", runPromote},
+		{"demote", "Demote the primary cluster", runDemote},
+		{"heal", "Revoke and re-issue the secondary activation token, then update the secondary's primary", runHeal},
+		{"token", "Generate an operation batch token and store it in the KV engine ('token rotate' to replace it unattended)", runToken},
+		{"watch", "Continuously discover the topology and act on automatic scenarios as they arise", runWatch},
+		{"serve", "Serve an HTTP control API for triggering evaluation and failover", runServe},
+		{"reindex", "Reindex the replicated data on a cluster and track its progress, or check its merkle tree ('-merkleCheck')", runReindex},
+		{"shares", "Serve an endpoint for key holders to submit key shares for DR operation token generation ('shares serve')", runShares},
+	}
+}
+
+// Dispatch to the requested subcommand, defaulting to evaluate when none is given
+func runCommand(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runEvaluate(args)
+		return
+	}
+
+	for _, cmd := range getCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	printUsage()
+	if args[0] == "help" {
+		os.Exit(0)
+	}
+	log.Fatalf("Unknown command: %s\n", args[0])
+}
+
+// Print the top-level usage, listing the supported subcommands
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: vault-fm-operator <command> [flags]\n\nCommands:\n")
+	for _, cmd := range getCommands() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'vault-fm-operator <command> -h' for the flags supported by a command\n")
+}
+
+// Create a flag set for a subcommand with the flags shared by all subcommands
+func (c *ConfigData) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: vault-fm-operator %s [flags]\n", name)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&c.ClientConfig.ConfiguredAddrs, "addresses", "https://localhost:8200,https://localhost:8300", "Comma-separated list of two or more Vault addresses in a replication relationship")
+	fs.StringVar(&c.ClientConfig.OpBatchToken, "opBatchToken", "", "Operation batch token with a policy that allows for the manipulation of replication configurations on either cluster")
+	fs.Var(&c.ClientConfig.OpBatchTokens, "opBatchTokens", "Comma-separated list of <address or cluster name>=<token> pairs, for clusters with their own token store such as performance secondaries")
+	fs.BoolVar(&c.ClientConfig.OpBatchTokenFromKV, "opBatchTokenFromKV", false, "Read the operation batch token from the KV engine at -tokenKvMount, using the identity from -authMethod or $VAULT_TOKEN")
+	fs.BoolVar(&c.ClientConfig.TlsSkipVerify, "tlsSkipVerify", false, "Skip TLS verification of the Vault server's certificate")
+	fs.StringVar(&c.ClientConfig.Mode, "mode", "", "Replication mode to evaluate ('dr', 'performance' or 'combined'); detected from the clusters when omitted")
+	fs.StringVar(&c.TokenKvMount, "tokenKvMount", "kv", "KV engine mount point where the generated operation token should be stored")
+	fs.BoolVar(&c.ClientConfig.DryRun, "dryRun", false, "Print the ordered steps that would be taken without executing them")
+	fs.IntVar(&c.ClientConfig.Lag.MaxWalDelta, "maxWalDelta", 0, "Maximum number of WALs the secondary may trail the primary by when promoted (0 disables the check)")
+	fs.DurationVar(&c.ClientConfig.Lag.MaxHeartbeatAge, "maxHeartbeatAge", 0, "Maximum age of the secondary's last heartbeat from the primary when promoted (0 disables the check)")
+	fs.DurationVar(&c.ClientConfig.Lag.WaitTimeout, "lagWaitTimeout", 5*time.Minute, "How long a planned failover waits for the secondary to catch up with the primary")
+	fs.Var(&c.ClientConfig.Lag.AllowDataLoss, "allowDataLoss", "Promote a secondary without a healthy primary even when its replication lag exceeds, or cannot be measured against, the given limits ('maxWalDelta', 'maxHeartbeatAge' or both, comma-separated); all limits when given without a value")
+	fs.DurationVar(&c.ClientConfig.Health.MaxClockSkew, "maxClockSkew", 10*time.Second, "Clock skew between replication peers beyond which replication is reported unhealthy (0 disables the check)")
+	fs.DurationVar(&c.ClientConfig.Health.StaleHeartbeat, "staleHeartbeatAge", 30*time.Second, "Age beyond which a replication peer's last heartbeat is considered stale (0 disables the check)")
+	fs.DurationVar(&c.ClientConfig.Health.MaxCanaryAge, "maxCanaryAge", time.Minute, "Replication canary age beyond which replication is reported unhealthy (0 disables the check)")
+	fs.BoolVar(&c.ClientConfig.AllowCorruptedMerkle, "allowCorruptedMerkle", false, "Promote a secondary whose merkle tree is corrupted or that is in merkle-sync or merkle-diff")
+	fs.BoolVar(&c.ClientConfig.AllowLivePrimary, "allowLivePrimary", false, "Promote a secondary without a reachable primary even while the secondary is still connected to a primary with fresh heartbeats")
+	fs.BoolVar(&c.ClientConfig.AllowUncheckedDrCapabilities, "allowUncheckedDrCapabilities", false, "Proceed with steps on a DR secondary whose token capabilities cannot be checked because the DR primary is unavailable")
+	fs.StringVar(&c.ClientConfig.Witness.Addrs, "witnesses", "", "Comma-separated list of witness endpoints (other operator instances or HTTP probes) that must agree the primary is down before an automated promotion")
+	fs.IntVar(&c.ClientConfig.Witness.Quorum, "witnessQuorum", 0, "Number of witnesses that must agree the primary is down (defaults to a majority of -witnesses)")
+	fs.StringVar(&c.ClientConfig.Witness.Token, "witnessToken", os.Getenv("VAULT_FM_WITNESS_TOKEN"), "Bearer token sent to witness endpoints (defaults to $VAULT_FM_WITNESS_TOKEN)")
+	fs.StringVar(&c.ClientConfig.Witness.CertFile, "witnessCertFile", "", "Client certificate file presented to witnesses that require mutual TLS")
+	fs.StringVar(&c.ClientConfig.Witness.KeyFile, "witnessKeyFile", "", "Client key file presented to witnesses that require mutual TLS")
+	fs.StringVar(&c.ClientConfig.Witness.CAFile, "witnessCaFile", "", "CA file used to verify witness certificates (defaults to the system roots)")
+	fs.DurationVar(&c.ClientConfig.MinTokenTTL, "minTokenTtl", 10*time.Minute, "Minimum remaining TTL of the operation token required to start an operation (0 disables the check)")
+	fs.StringVar(&c.ClientConfig.Auth.Method, "authMethod", "token", "Auth method used to obtain a token on each cluster ('token', 'approle', 'kubernetes', 'cert' or 'userpass'); 'token' uses -opBatchToken directly")
+	fs.StringVar(&c.ClientConfig.Auth.Mount, "authMount", "", "Mount path of the auth method (defaults to the method name)")
+	fs.StringVar(&c.ClientConfig.Auth.Role, "authRole", "", "Role to log in with for kubernetes auth, or the certificate role name for cert auth")
+	fs.StringVar(&c.ClientConfig.Auth.RoleID, "authRoleId", "", "Role ID for approle auth")
+	fs.StringVar(&c.ClientConfig.Auth.SecretIDFile, "authSecretIdFile", "", "File containing the secret ID for approle auth (defaults to $VAULT_FM_SECRET_ID)")
+	fs.StringVar(&c.ClientConfig.Auth.JwtFile, "authJwtFile", "/var/run/secrets/kubernetes.io/serviceaccount/token", "File containing the service account JWT for kubernetes auth")
+	fs.StringVar(&c.ClientConfig.Auth.CertFile, "authCertFile", "", "Client certificate file for cert auth")
+	fs.StringVar(&c.ClientConfig.Auth.KeyFile, "authKeyFile", "", "Client key file for cert auth")
+	fs.StringVar(&c.ClientConfig.Auth.Username, "authUsername", "", "Username for userpass auth (password from $VAULT_FM_PASSWORD, or prompted)")
+	return fs
+}
+
+// Register the flags that allow an operation batch token to be generated
+// without prompting the operator
+func (c *ConfigData) generateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ClientConfig.Generate.TTL, "ttl", "", "TTL of a generated operation batch token (prompted if empty)")
+	c.privilegedTokenFlags(fs)
+	fs.BoolVar(&c.ClientConfig.Generate.Confirmed, "confirm", false, "Generate an operation batch token without asking for confirmation")
+}
+
+// Register the flags that supply the privileged token used to generate or
+// rotate an operation batch token
+func (c *ConfigData) privilegedTokenFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ClientConfig.Generate.PrivilegedTokenFile, "privilegedTokenFile", "", "File containing the privileged token used to generate an operation batch token, such as a Vault Agent file sink (defaults to $VAULT_TOKEN, or prompted)")
+	fs.Var(&c.ClientConfig.Generate.PrivilegedTokenFiles, "privilegedTokenFiles", "Comma-separated list of <address or cluster name>=<file> pairs, for clusters with their own token store such as performance secondaries")
+}
+
+// Parse the flags for a subcommand, then verify the configured addresses and
+// initialize clients for the discovered clusters. In combined mode, discovery
+// happens separately for each replication group during evaluation.
+func (c *ConfigData) setup(fs *flag.FlagSet, args []string) {
+	c.parseFlags(fs, args)
+	if c.ClientConfig.Mode != "combined" {
+		c.initialize()
+	}
+}
+
+// Parse the flags for a subcommand and ensure required values are present
+func (c *ConfigData) parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Value.String() == "" && !optionalFlags[f.Name] {
+			log.Fatalf("Missing required flag: %s\n", f.Name)
+		}
+	})
+
+	if c.ClientConfig.Mode == "" {
+		mode, err := c.detectMode(fs.Name())
+		if err != nil {
+			log.Fatalf("Could not detect replication mode: %v\n", err)
+		}
+		log.Println("Detected replication mode:", mode)
+		c.ClientConfig.Mode = mode
+	}
+
+	switch c.ClientConfig.Mode {
+	case "dr", "performance":
+	case "combined":
+		if !supportsCombined(fs.Name()) {
+			log.Fatalf("Replication mode combined is only supported by the evaluate and plan commands\n")
+		}
+	default:
+		log.Fatalf("Invalid replication mode: %s\n", c.ClientConfig.Mode)
+	}
+
+	if c.ClientConfig.OpBatchTokenFromKV && c.ClientConfig.OpBatchToken != "" {
+		log.Fatalln("Only one of -opBatchToken and -opBatchTokenFromKV may be set")
+	}
+
+	auth, err := c.ClientConfig.buildAuthenticator()
+	if err != nil {
+		log.Fatalf("Invalid auth configuration: %v\n", err)
+	}
+	c.ClientConfig.authenticator = auth
+	c.ClientConfig.tokens = &tokenCache{tokens: map[string]cachedToken{}}
+	c.ClientConfig.Generate.unwrapped = map[string]string{}
+}
+
+// Evaluate the discovered topology and act on the resulting scenario
+func runEvaluate(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("evaluate")
+	fs.StringVar(&c.ClientConfig.RecoveryPgpKeyFile, "recoveryPgpKey", "", "File containing a base64-encoded PGP public key used to encrypt a DR operation token generated from key shares (an OTP is used if empty)")
+	c.generateFlags(fs)
+	c.setup(fs, args)
+	err := c.evaluate()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
+
+// Report the discovered topology
+func runStatus(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("status")
+	output := fs.String("output", "table", "Output format ('json', 'yaml' or 'table')")
+	c.setup(fs, args)
+
+	err := writeStatusReport(os.Stdout, c.getStatusReport(), *output)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// Print the scenario and steps that evaluate would act on
+func runPlan(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("plan")
+	c.ClientConfig.DryRun = true
+	c.setup(fs, args)
+	err := c.evaluate()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// Fail over from the primary to the secondary cluster
+func runFailover(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("failover")
+	force := fs.Bool("force", false, "Skip the confirmation prompt")
+	demotePrimary := fs.Bool("demotePrimary", true, "Demote the primary cluster before promoting the secondary")
+	c.setup(fs, args)
+
+	if c.SecondaryCluster.Client == nil {
+		log.Fatalln("Secondary cluster client is not initialized - cannot fail over")
+	}
+	if *demotePrimary && c.PrimaryCluster.Client == nil {
+		log.Fatalln("Primary cluster client is not initialized - cannot demote primary")
+	}
+	err := c.failover(*demotePrimary, *force)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
+
+// Promote the secondary cluster
+func runPromote(args []string) {
+	c := ConfigData{}
+	c.setup(c.newFlagSet("promote"), args)
+
+	if c.SecondaryCluster.Client == nil {
+		log.Fatalln("Secondary cluster client is not initialized - cannot promote")
+	}
+	err := c.execute(append(c.forcedPromotionChecks(&c.SecondaryCluster), c.promoteStep(&c.SecondaryCluster)))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
+
+// Demote the primary cluster
+func runDemote(args []string) {
+	c := ConfigData{}
+	c.setup(c.newFlagSet("demote"), args)
+
+	if c.PrimaryCluster.Client == nil {
+		log.Fatalln("Primary cluster client is not initialized - cannot demote")
+	}
+	err := c.execute([]step{c.demoteStep(&c.PrimaryCluster)})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
+
+// Re-establish replication between a healthy primary and a disconnected secondary
+func runHeal(args []string) {
+	c := ConfigData{}
+	c.setup(c.newFlagSet("heal"), args)
+
+	err := c.heal()
+	if err != nil {
+		log.Fatalf("heal: %v", err)
+	}
+	c.logCompletion()
+}
+
+// Generate a new operation batch token, or rotate the stored token
+func runToken(args []string) {
+	if len(args) > 0 && args[0] == "rotate" {
+		runTokenRotate(args[1:])
+		return
+	}
+
+	c := ConfigData{}
+	fs := c.newFlagSet("token")
+	c.generateFlags(fs)
+	c.setup(fs, args)
+
+	err := c.execute([]step{c.generateTokenStep()})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// Replace the stored operation batch token with a newly-minted one
+func runTokenRotate(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("token rotate")
+	ttl := fs.String("ttl", "168h", "TTL of the new operation batch token")
+	c.privilegedTokenFlags(fs)
+	c.ClientConfig.Unattended = true
+	c.setup(fs, args)
+
+	if c.ClientConfig.DryRun {
+		fmt.Printf("Would create a batch token with the %s policy and a TTL of %s, and store it at %s/%s%s\n", handlerPolicyName, *ttl, c.TokenKvMount, tokenKvPath, c.clusterTokenDescription())
+		return
+	}
+	err := c.rotateOpBatchToken(*ttl)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
+
+// Log successful completion of an operation, unless nothing was executed
+func (c *ConfigData) logCompletion() {
+	if !c.ClientConfig.DryRun {
+		log.Println("Operation completed successfully")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+// Build the ordered steps required to resolve a conflict between multiple
+// primary clusters by demoting every primary other than the one with the
+// highest WAL and re-establishing replication from the remaining primary. Note
+// that this may not always be the correct resolution, as the primary with the
+// lowest WAL may not universally be the best choice for demotion.
+func (c *ConfigData) primaryConflictSteps() []step {
+	var steps []step
+	for _, cluster := range c.secondaries() {
+		if !cluster.Leader {
+			continue
+		}
+		steps = append(steps,
+			c.demoteStep(cluster),
+			c.waitForSecondaryStep(cluster),
+			c.revokeSecondaryStep(&c.PrimaryCluster, cluster),
+			c.activationTokenStep(&c.PrimaryCluster, cluster),
+			c.updatePrimaryStep(cluster),
+		)
+	}
+	return steps
+}
+
+// Build the ordered steps required to resolve a conflict between secondary
+// clusters with no primary by promoting the best promotion candidate and
+// pointing the remaining secondaries to it
+func (c *ConfigData) secondaryConflictSteps() []step {
+	steps := append(c.forcedPromotionChecks(&c.PrimaryCluster), c.promoteStep(&c.PrimaryCluster))
+	return append(steps, c.repointSteps(&c.PrimaryCluster, c.secondaries())...)
+}
@@ -4,15 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/hashicorp/vault-client-go"
 )
 
 // Demote a primary cluster
-func (c *ConfigData) demote() error {
+func (c *ConfigData) demote(client *vault.Client) error {
 	log.Println("Demoting primary cluster...")
-	_, err := c.PrimaryCluster.Client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/primary/demote", nil)
+	_, err := client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/primary/demote", nil)
 	if err != nil {
 		return fmt.Errorf("primary demotion operation failed: %w", err)
 	}
@@ -20,10 +19,19 @@ func (c *ConfigData) demote() error {
 	return nil
 }
 
-// Get a new secondary activation token
-func (c *ConfigData) getActivationToken(client *vault.Client) error {
+// Return the ID under which a secondary cluster is known to its primary. A
+// cluster that has not yet been a secondary uses the default ID.
+func (cluster *ClusterData) activationTokenId() string {
+	if cluster.SecondaryID != "" {
+		return cluster.SecondaryID
+	}
+	return "secondary-token"
+}
+
+// Get a new secondary activation token for the secondary with the given ID
+func (c *ConfigData) getActivationToken(client *vault.Client, id string) error {
 	var activationTokenPayload = map[string]interface{}{
-		"id": "secondary-token",
+		"id": id,
 	}
 	resp, err := client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/primary/secondary-token", activationTokenPayload)
 	if err != nil {
@@ -35,14 +43,18 @@ func (c *ConfigData) getActivationToken(client *vault.Client) error {
 }
 
 // Update a secondary cluster with a new primary address
-func (c *ConfigData) updatePrimary(client *vault.Client, terminate bool) error {
+func (c *ConfigData) updatePrimary(client *vault.Client) error {
 	log.Println("Updating new secondary cluster with new primary address")
 	var updatePayload map[string]interface{}
 
 	switch c.ClientConfig.Mode {
 	case "dr":
+		drOperationToken, err := c.drOperationToken()
+		if err != nil {
+			return fmt.Errorf("update-primary operation failed: %w", err)
+		}
 		updatePayload = map[string]interface{}{
-			"dr_operation_token": c.ClientConfig.OpBatchToken,
+			"dr_operation_token": drOperationToken,
 			"token":              c.SecondaryActivationToken,
 		}
 	case "performance":
@@ -52,15 +64,9 @@ func (c *ConfigData) updatePrimary(client *vault.Client, terminate bool) error {
 	}
 	_, err := client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/secondary/update-primary", updatePayload)
 	if err != nil {
-		log.Printf("%v\n", c)
 		return fmt.Errorf("update-primary operation failed: %w", err)
 	}
 	log.Println("Successfully updated secondary cluster with new primary address")
 
-	if terminate {
-		log.Println("Operation completed successfully")
-		os.Exit(0)
-	}
-
 	return nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Detect the replication mode from the replication types enabled on the
+// configured clusters. When both DR and performance replication are enabled the
+// result is ambiguous, so the operator is asked to choose, or detection fails
+// when running unattended or without a terminal to prompt on.
+func (c *ConfigData) detectMode(command string) (string, error) {
+	err := c.ClientConfig.verifyAddrs()
+	if err != nil {
+		return "", err
+	}
+	snapshots, err := c.getCombinedStatus(c.ClientConfig.VerifiedAddrs)
+	if err != nil {
+		return "", err
+	}
+
+	var dr, performance bool
+	for _, snapshot := range snapshots {
+		dr = dr || replicationEnabled(snapshot.Dr.Mode)
+		performance = performance || replicationEnabled(snapshot.Performance.Mode)
+	}
+
+	switch {
+	case dr && !performance:
+		return "dr", nil
+	case performance && !dr:
+		return "performance", nil
+	case !dr && !performance:
+		return "", fmt.Errorf("replication is not enabled on any of the configured clusters")
+	}
+
+	modes := []string{"dr", "performance"}
+	if supportsCombined(command) {
+		modes = append(modes, "combined")
+	}
+	if c.ClientConfig.Unattended || !stdinIsTerminal() {
+		return "", fmt.Errorf("both DR and performance replication are enabled - set -mode to one of: %s", strings.Join(modes, ", "))
+	}
+
+	var mode string
+	fmt.Printf("Both DR and performance replication are enabled. Replication mode to evaluate (%s): ", strings.Join(modes, ", "))
+	fmt.Scan(&mode)
+	for _, m := range modes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid replication mode: %s", mode)
+}
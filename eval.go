@@ -1,12 +1,26 @@
 package main
 
 import (
+	"errors"
 	"log"
 )
 
-// Evaluate the current state of the primary and secondary clusters and
-// determine if a promotion scenario is possible
-func (c *ConfigData) evaluate() {
+// A replication scenario matched against the discovered topology, along with
+// the action that should be taken in response. Automatic scenarios are acted on
+// without prompting, and may be acted on by an unattended operator.
+type scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Warning     string `json:"warning,omitempty"`
+	Fatal       bool   `json:"fatal,omitempty"`
+	Automatic   bool   `json:"automatic,omitempty"`
+	Steps       []step `json:"steps,omitempty"`
+	// the action recorded in metrics when the scenario's steps are executed
+	action string
+}
+
+// Log the result of replication relationship confirmation for the configured mode
+func (c *ConfigData) confirmReplication() {
 	switch c.ClientConfig.Mode {
 	case "dr":
 		if c.PrimaryDrConfig.ClusterID == c.SecondaryDrConfig.ClusterID && c.PrimaryDrConfig.ClusterID != "" {
@@ -21,50 +35,202 @@ func (c *ConfigData) evaluate() {
 			log.Println("Could not confirm replication relationship")
 		}
 	}
+}
+
+// Report whether the primary and every secondary cluster are healthy
+func (c *ConfigData) allHealthy() bool {
+	if !c.PrimaryCluster.Healthy {
+		return false
+	}
+	for _, secondary := range c.secondaries() {
+		if !secondary.Healthy {
+			return false
+		}
+	}
+	return true
+}
 
+// Report whether the operation token is valid for the role of each cluster. It
+// must be valid on every healthy secondary: verified against each performance
+// secondary's own token store, or usable as a dr_operation_token on each DR
+// secondary. It must also be verified against the primary while the primary is
+// healthy; otherwise a token valid on the secondaries is enough to promote one
+// of them.
+func (c *ConfigData) tokenValid() bool {
+	if c.PrimaryCluster.Healthy && !c.PrimaryCluster.TokenValid {
+		return false
+	}
+	verified := c.PrimaryCluster.Healthy
+	for _, secondary := range c.secondaries() {
+		if !secondary.Healthy {
+			continue
+		}
+		if !secondary.TokenValid {
+			return false
+		}
+		verified = true
+	}
+	return verified
+}
+
+// Match the current state of the primary and secondary clusters against the
+// known replication scenarios
+func (c *ConfigData) selectScenario() scenario {
 	switch {
-	case !c.OpBatchTokenValid || !c.OpBatchTokenVerified:
-		log.Println("Operation batch token is invalid or could not be verified")
-		err := generateOpBatchToken(c)
-		if err != nil {
-			log.Fatalf("%v", err)
-		}
-	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && c.SecondaryCluster.Follower && c.SecondaryCluster.Connected && c.OpBatchTokenValid:
-		log.Println("Secondary promotion with primary demotion (failover) can be safely initiated")
-		c.failover(true, false)
-	case !c.OpBatchTokenValid && !c.PrimaryCluster.Healthy && c.ClientConfig.Mode == "dr":
-		log.Fatalln("Operation batch token is invalid and primary cluster is not healthy - proceeding with DR operation token generation using secondary cluster recovery method")
-		// c.generateOpBatchToken("recovery")
+	case !c.tokenValid() && !c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.SecondaryCluster.Follower && c.ClientConfig.Mode == "dr":
+		// check for a partition before key holders are asked for their shares
+		steps := []step{
+			c.partitionCheckStep(&c.SecondaryCluster),
+			c.confirmStep("Proceeed with DR operation token generation and secondary promotion?"),
+			c.recoverTokenStep(&c.SecondaryCluster),
+		}
+		return scenario{
+			Name:        "token-recovery",
+			Description: "Operation batch token is invalid and primary cluster is not healthy - proceeding with DR operation token generation using secondary cluster recovery method, followed by secondary promotion",
+			Warning:     "ensure old primary is quarantined and demoted before re-establishing client connectivity",
+			Steps:       append(steps, c.failoverSteps(false, true)...),
+		}
+	case !c.tokenValid():
+		return scenario{
+			Name:        "token-invalid",
+			Description: "Operation batch token is invalid or could not be verified",
+			Steps:       []step{c.generateTokenStep()},
+		}
+	case c.Conflict != "" && !c.allHealthy():
+		return scenario{
+			Name:        "conflict-unhealthy",
+			Description: "Multiple " + c.Conflict + " clusters detected but not all clusters are healthy - manual intervention is required",
+			Fatal:       true,
+		}
+	case c.Conflict == "primary":
+		return scenario{
+			Name:        "dual-primary",
+			Description: "Multiple primary clusters detected - the primary with the lowest WAL will be demoted and replication re-established",
+			Automatic:   true,
+			Steps:       c.primaryConflictSteps(),
+			action:      "conflict_resolution",
+		}
+	case c.Conflict == "secondary":
+		return scenario{
+			Name:        "dual-secondary",
+			Description: "Multiple secondary clusters detected - the secondary with the highest WAL will be promoted and replication re-established",
+			Automatic:   true,
+			Steps:       c.automatedPromotionSteps(&c.PrimaryCluster, c.secondaryConflictSteps()),
+			action:      "conflict_resolution",
+		}
+	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && c.SecondaryCluster.Follower && len(c.disconnectedSecondaries()) > 0:
+		return scenario{
+			Name:        "heal",
+			Description: "Clusters are healthy but one or more secondaries are not connected to the primary - an attempt will be made to re-establish healthy replication",
+			Automatic:   true,
+			Steps:       c.healSteps(c.disconnectedSecondaries()),
+			action:      "heal",
+		}
+	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && c.SecondaryCluster.Follower && len(c.staleSecondaries()) > 0:
+		return scenario{
+			Name:        "stale-heartbeat",
+			Description: "One or more secondaries report a connection to the primary but their heartbeats are stale - replication may have stalled",
+			Warning:     "check network connectivity between the clusters before re-establishing replication",
+			Steps:       append([]step{c.confirmStep("Re-establish replication to the secondaries with stale heartbeats?")}, c.healSteps(c.staleSecondaries())...),
+			action:      "heal",
+		}
+	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && c.SecondaryCluster.Follower && c.SecondaryCluster.Connected && c.tokenValid():
+		return scenario{
+			Name:        "failover",
+			Description: "Secondary promotion with primary demotion (failover) can be safely initiated",
+			Steps:       c.failoverSteps(true, false),
+		}
 	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && !c.SecondaryCluster.Follower:
-		log.Fatalln("The configured secondary cluster is not in a follower state - this could indicate a split-brain scenario")
+		return scenario{
+			Name:        "secondary-not-follower",
+			Description: "The configured secondary cluster is not in a follower state - this could indicate a split-brain scenario",
+			Fatal:       true,
+		}
 	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && !c.PrimaryCluster.Leader && c.SecondaryCluster.Follower:
-		log.Fatalln("The configured primary cluster is not in a leader state - this could indicate a split-brain scenario")
+		return scenario{
+			Name:        "primary-not-leader",
+			Description: "The configured primary cluster is not in a leader state - this could indicate a split-brain scenario",
+			Fatal:       true,
+		}
 	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && !c.PrimaryCluster.Leader && !c.SecondaryCluster.Follower:
-		log.Fatalln("Both configured primary and secondary clusters are not in an expected replication state")
+		return scenario{
+			Name:        "unexpected-state",
+			Description: "Both configured primary and secondary clusters are not in an expected replication state",
+			Fatal:       true,
+		}
 	case !c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.SecondaryCluster.Follower && !c.SecondaryCluster.Connected:
-		log.Println("Primary cluster unhealthy and secondary is not connected to the primary - proceeding with secondary promotion")
-		log.Println("WARNING: ensure old primary is quarantined and demoted before re-establishing client connectivity")
-		c.failover(false, true)
-	case c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.PrimaryCluster.Leader && c.SecondaryCluster.Follower && !c.SecondaryCluster.Connected:
-		log.Println("Clusters are healthy but secondary is not connected to the primary - an attempt will be made to re-establish healthy replication")
-		client := c.getHttpClient()
-		err := c.revokeSecondary(c.PrimaryCluster.Addr, client)
-		if err != nil {
-			log.Fatalf("revoke secondary: %v", err)
-		}
-		err = c.getActivationToken(c.PrimaryCluster.Client)
-		if err != nil {
-			log.Fatalf("get activation token: %v", err)
-		}
-		err = c.updatePrimary(c.SecondaryCluster.Client, true)
-		if err != nil {
-			log.Fatalf("%v", err)
+		return scenario{
+			Name:        "promote-disconnected-secondary",
+			Description: "Primary cluster unhealthy and secondary is not connected to the primary - proceeding with secondary promotion",
+			Warning:     "ensure old primary is quarantined and demoted before re-establishing client connectivity",
+			Automatic:   true,
+			Steps:       c.automatedPromotionSteps(&c.SecondaryCluster, c.failoverSteps(false, true)),
+		}
+	case !c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.SecondaryCluster.Follower && c.SecondaryCluster.Connected && !c.SecondaryCluster.StaleHeartbeat && !c.ClientConfig.AllowLivePrimary:
+		return scenario{
+			Name:        "primary-visible-to-secondary",
+			Description: "Primary cluster unreachable by the operator, but the secondary is still connected to it with fresh heartbeats - the operator may be partitioned from the primary, so the secondary will not be promoted (override with -allowLivePrimary)",
+			Fatal:       true,
 		}
 	case !c.PrimaryCluster.Healthy && c.SecondaryCluster.Healthy && c.SecondaryCluster.Follower && c.SecondaryCluster.Connected:
-		log.Println("Primary cluster unhealthy but secondary is connected to the primary - proceeding with secondary promotion")
-		log.Println("WARNING: ensure old primary is quarantined and demoted before re-establishing client connectivity")
-		c.failover(false, true)
+		return scenario{
+			Name:        "promote-connected-secondary",
+			Description: "Primary cluster unhealthy but secondary is connected to the primary - proceeding with secondary promotion",
+			Warning:     "ensure old primary is quarantined and demoted before re-establishing client connectivity",
+			Automatic:   true,
+			Steps:       c.automatedPromotionSteps(&c.SecondaryCluster, c.failoverSteps(false, true)),
+		}
 	default:
-		log.Println("Could not determine a valid promotion scenario - manual intervention is required")
+		return scenario{
+			Name:        "unknown",
+			Description: "Could not determine a valid promotion scenario - manual intervention is required",
+		}
+	}
+}
+
+// Evaluate the current state of the primary and secondary clusters and
+// determine if a promotion scenario is possible. When dry-run is enabled, the
+// selected scenario and its steps are printed and nothing is executed.
+func (c *ConfigData) evaluate() error {
+	if c.ClientConfig.Mode == "combined" {
+		return c.evaluateCombined()
+	}
+	c.confirmReplication()
+	return c.act(c.selectScenario())
+}
+
+// Act on a selected scenario, aborting if it is fatal. When dry-run is enabled,
+// the scenario and its steps are printed and nothing is executed.
+func (c *ConfigData) act(s scenario) error {
+	if c.ClientConfig.DryRun {
+		printPlan(s)
+		return nil
+	}
+	if s.Fatal {
+		return errors.New(s.Description)
+	}
+	log.Println(s.Description)
+	if s.Warning != "" {
+		log.Println("WARNING:", s.Warning)
+	}
+	return c.executeScenario(s)
+}
+
+// Report whether acting on the scenario promotes a secondary cluster
+func (s scenario) promotes() bool {
+	switch s.Name {
+	case "failover", "dual-secondary", "promote-disconnected-secondary", "promote-connected-secondary":
+		return true
+	}
+	return false
+}
+
+// Execute the steps of a scenario, recording the outcome of scenario-level
+// actions such as healing and conflict resolution
+func (c *ConfigData) executeScenario(s scenario) error {
+	err := c.execute(s.Steps)
+	if s.action != "" && !c.ClientConfig.DryRun {
+		recordAction(s.action, err)
 	}
+	return err
 }
@@ -0,0 +1,167 @@
+package main
+
+import "testing"
+
+func TestSelectScenario(t *testing.T) {
+	healthyPrimary := ClusterData{Addr: "https://a:8200", Healthy: true, Leader: true, TokenValid: true}
+	secondary := ClusterData{Addr: "https://b:8200", Healthy: true, Follower: true, TokenValid: true}
+
+	tests := []struct {
+		name      string
+		mode      string
+		primary   ClusterData
+		secondary func(ClusterData) ClusterData
+		allowLive bool
+		want      string
+	}{
+		{
+			name:      "primary down, secondary disconnected",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { return s },
+			want:      "promote-disconnected-secondary",
+		},
+		{
+			name:      "primary down, secondary still sees a live primary",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { s.Connected = true; return s },
+			want:      "primary-visible-to-secondary",
+		},
+		{
+			name:      "primary down, secondary connected with stale heartbeats",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { s.Connected = true; s.StaleHeartbeat = true; return s },
+			want:      "promote-connected-secondary",
+		},
+		{
+			name:      "primary down, live primary overridden",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { s.Connected = true; return s },
+			allowLive: true,
+			want:      "promote-connected-secondary",
+		},
+		{
+			name:      "dr primary down, dr operation token confirmed",
+			mode:      "dr",
+			secondary: func(s ClusterData) ClusterData { return s },
+			want:      "promote-disconnected-secondary",
+		},
+		{
+			name:      "dr primary down, dr operation token unverified",
+			mode:      "dr",
+			secondary: func(s ClusterData) ClusterData { s.TokenValid = false; return s },
+			want:      "token-recovery",
+		},
+		{
+			name:      "performance primary down, secondary token rejected",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { s.TokenValid = false; return s },
+			want:      "token-invalid",
+		},
+		{
+			name:      "primary down, secondary not a follower",
+			mode:      "performance",
+			secondary: func(s ClusterData) ClusterData { s.Follower = false; return s },
+			want:      "unknown",
+		},
+		{
+			name:      "both healthy and connected",
+			mode:      "dr",
+			primary:   healthyPrimary,
+			secondary: func(s ClusterData) ClusterData { s.Connected = true; return s },
+			want:      "failover",
+		},
+		{
+			name: "both healthy, token rejected by the primary",
+			mode: "dr",
+			primary: func() ClusterData {
+				p := healthyPrimary
+				p.TokenValid = false
+				return p
+			}(),
+			secondary: func(s ClusterData) ClusterData { s.Connected = true; return s },
+			want:      "token-invalid",
+		},
+		{
+			name:      "both healthy, secondary disconnected",
+			mode:      "dr",
+			primary:   healthyPrimary,
+			secondary: func(s ClusterData) ClusterData { return s },
+			want:      "heal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ConfigData{
+				ClientConfig: ClientConfig{
+					Mode:             tt.mode,
+					ConfiguredAddrs:  "https://a:8200,https://b:8200",
+					AllowLivePrimary: tt.allowLive,
+				},
+				PrimaryCluster:   tt.primary,
+				SecondaryCluster: tt.secondary(secondary),
+			}
+			got := c.selectScenario()
+			if got.Name != tt.want {
+				t.Errorf("selectScenario() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectScenarioWitnessGate(t *testing.T) {
+	tests := []struct {
+		name string
+		c    ConfigData
+	}{
+		{
+			name: "promote-disconnected-secondary",
+			c: ConfigData{
+				SecondaryCluster: ClusterData{Addr: "https://b:8200", Healthy: true, Follower: true, TokenValid: true},
+			},
+		},
+		{
+			name: "promote-connected-secondary",
+			c: ConfigData{
+				SecondaryCluster: ClusterData{Addr: "https://b:8200", Healthy: true, Follower: true, Connected: true, StaleHeartbeat: true, TokenValid: true},
+			},
+		},
+		{
+			name: "dual-secondary",
+			c: ConfigData{
+				Conflict:         "secondary",
+				PrimaryCluster:   ClusterData{Addr: "https://a:8200", Healthy: true, Follower: true, TokenValid: true},
+				SecondaryCluster: ClusterData{Addr: "https://b:8200", Healthy: true, Follower: true, TokenValid: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.c
+			c.ClientConfig = ClientConfig{
+				Mode:            "performance",
+				ConfiguredAddrs: "https://a:8200,https://b:8200",
+				Witness:         WitnessConfig{Addrs: "https://w1:9090,https://w2:9090"},
+			}
+			s := c.selectScenario()
+			if s.Name != tt.name {
+				t.Fatalf("selectScenario() = %s, want %s", s.Name, tt.name)
+			}
+			if !s.Automatic || !s.promotes() {
+				t.Fatalf("scenario %s is not an automatic promotion", s.Name)
+			}
+			if len(s.Steps) == 0 || s.Steps[0].Description != c.witnessQuorumStep(promotionTarget(&c, s)).Description {
+				t.Errorf("first step of %s is not the witness quorum check", s.Name)
+			}
+		})
+	}
+}
+
+// Return the cluster promoted by an automatic scenario
+func promotionTarget(c *ConfigData, s scenario) *ClusterData {
+	if s.Name == "dual-secondary" {
+		return &c.PrimaryCluster
+	}
+	return &c.SecondaryCluster
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Return when the first of the operation tokens in use expires, and the address
+// of the cluster it was checked against. Batch tokens cannot be renewed, so
+// a zero time is returned only when no token has a TTL.
+func (c *ConfigData) tokenExpiry() (time.Time, string) {
+	var expires time.Time
+	var addr string
+	for _, snapshot := range c.Topology.Clusters {
+		if !snapshot.TokenValid || snapshot.TokenTTL <= 0 {
+			continue
+		}
+		at := c.Topology.DiscoveredAt.Add(time.Duration(snapshot.TokenTTL) * time.Second)
+		if expires.IsZero() || at.Before(expires) {
+			expires, addr = at, snapshot.Addr
+		}
+	}
+	return expires, addr
+}
+
+// Refuse to start an operation when the operation token would expire before it
+// is likely to complete. Waiting for a demoted primary and for a promoted
+// secondary to become ready can take minutes, and the token cannot be renewed
+// partway through.
+func (c *ConfigData) checkTokenTTL() error {
+	expires, addr := c.tokenExpiry()
+	if expires.IsZero() {
+		return nil
+	}
+	remaining := time.Until(expires).Round(time.Second)
+	if remaining < c.ClientConfig.MinTokenTTL {
+		return fmt.Errorf("operation token for %s expires in %s, less than the minimum of %s required to start an operation - generate a new token with the token command", addr, remaining, c.ClientConfig.MinTokenTTL)
+	}
+	log.Printf("Operation token for %s expires in %s", addr, remaining)
+	return nil
+}
+
+// Log an alert once the operation token is within the alert window of expiring,
+// so that it can be replaced before an operation depends on it. The alert is
+// repeated only after a new token has been observed.
+func (w *watcher) checkTokenExpiry(c *ConfigData) {
+	expires, addr := c.tokenExpiry()
+	if expires.IsZero() || time.Until(expires) > w.tokenExpiryAlert {
+		w.expiryAlerted = false
+		return
+	}
+	if !w.expiryAlerted {
+		log.Printf("ALERT: operation token for %s expires in %s (at %s) - generate a new token with the token command", addr, time.Until(expires).Round(time.Second), expires.Format(time.RFC3339))
+		w.expiryAlerted = true
+	}
+}
@@ -1,56 +1,62 @@
 package main
 
-import (
-	"fmt"
-	"log"
-	"os"
-)
-
-// Failover a healthy cluster pair
-func (c *ConfigData) failover(demotePrimary bool, force bool) {
-	var dec string
+// Build the ordered steps required to fail over to the secondary cluster
+func (c *ConfigData) failoverSteps(demotePrimary bool, force bool) []step {
+	var steps []step
 
 	if !force {
-		fmt.Print("Proceeed with operation? [y/n]: ")
-		fmt.Scan(&dec)
-		if dec != "y" {
-			log.Fatalln("Operation aborted")
-			os.Exit(1)
-		}
+		steps = append(steps, c.confirmStep("Proceeed with operation?"))
 	}
 
-	// if the primary is healthy, demote it before promoting the secondary
+	// if the primary is healthy, wait for the secondary to catch up and demote
+	// the primary before promoting the secondary; otherwise report how much
+	// data may be lost
 	if demotePrimary {
-		err := c.demote()
-		if err != nil {
-			log.Fatalf("demote: %v", err)
+		if c.lagLimited() {
+			steps = append(steps, c.catchUpStep())
 		}
+		steps = append(steps, c.merkleCheckStep(&c.SecondaryCluster), c.demoteStep(&c.PrimaryCluster))
+	} else {
+		steps = append(steps, c.forcedPromotionChecks(&c.SecondaryCluster)...)
 	}
 
-	err := c.promote()
-	if err != nil {
-		log.Fatalf("promote: %v", err)
-	}
+	steps = append(steps, c.promoteStep(&c.SecondaryCluster))
 
 	if demotePrimary {
-		err = c.waitForSecondary(false)
-		if err != nil {
-			log.Fatalf("wait for secondary: %v", err)
-		}
+		steps = append(steps,
+			c.waitForSecondaryStep(&c.PrimaryCluster),
+			c.activationTokenStep(&c.SecondaryCluster, &c.PrimaryCluster),
+			// initialize the new secondary client
+			c.initClientStep(c.PrimaryCluster.Addr),
+			c.updatePrimaryStep(&c.PrimaryCluster),
+		)
+	}
 
-		err = c.getActivationToken(c.SecondaryCluster.Client)
-		if err != nil {
-			log.Fatalf("get activation token: %v", err)
-		}
+	return append(steps, c.repointSteps(&c.SecondaryCluster, c.additionalSecondaries())...)
+}
 
-		// initialize the new secondary client
-		err = c.initClient(c.PrimaryCluster.Addr)
-		if err != nil {
-			log.Fatalf("error initializing new secondary cluster client: %v", err)
-		}
-		err = c.updatePrimary(c.PrimaryCluster.Client, false)
-		if err != nil {
-			log.Fatalf("%v", err)
-		}
+// Build the checks made before promoting a cluster without first demoting a
+// healthy primary
+func (c *ConfigData) forcedPromotionChecks(cluster *ClusterData) []step {
+	return []step{c.partitionCheckStep(cluster), c.merkleCheckStep(cluster), c.lossWindowStep(cluster)}
+}
+
+// Build the ordered steps required to point each of the given secondaries at a
+// new primary, each with its own activation token. Any existing activation
+// token for the secondary is revoked on the new primary first.
+func (c *ConfigData) repointSteps(primary *ClusterData, secondaries []*ClusterData) []step {
+	var steps []step
+	for _, secondary := range secondaries {
+		steps = append(steps,
+			c.revokeSecondaryStep(primary, secondary),
+			c.activationTokenStep(primary, secondary),
+			c.updatePrimaryStep(secondary),
+		)
 	}
+	return steps
+}
+
+// Failover a healthy cluster pair
+func (c *ConfigData) failover(demotePrimary bool, force bool) error {
+	return c.execute(c.failoverSteps(demotePrimary, force))
 }
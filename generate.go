@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
@@ -32,9 +35,29 @@ path "sys/replication/+/primary/revoke-secondary" {
   capabilities = ["update"]
 }
 
+path "sys/replication/reindex" {
+  capabilities = ["update", "sudo"]
+}
+
+path "sys/replication/merkle-check" {
+  capabilities = ["update", "sudo"]
+}
+
+path "sys/replication/dr/secondary/reindex" {
+  capabilities = ["update"]
+}
+
+path "sys/replication/dr/secondary/merkle-check" {
+  capabilities = ["update"]
+}
+
 path "auth/token/lookup-self" {
 	capabilities = ["read"]
 }
+
+path "sys/capabilities-self" {
+	capabilities = ["update"]
+}
 `
 
 // Create a policy for the handler token
@@ -80,35 +103,201 @@ func verifyKvEngine(client *vault.Client, tokenKvMount string) (string, error) {
 	return kvVersion, nil
 }
 
-// Store the new operations token in the KV engine
-func storeToken(kvVersion string, client *vault.Client, batchToken string, tokenKvMount string) error {
+// Build the KV record for an operations token, recording who created it and when
+func tokenRecord(batchToken string, creatorName string) map[string]interface{} {
+	return map[string]interface{}{
+		"token":      batchToken,
+		"created_by": creatorName,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// Store an operations token record in the KV engine at the given path
+func storeToken(kvVersion string, client *vault.Client, record map[string]interface{}, tokenKvMount string, path string) error {
 	if kvVersion == "2" {
-		_, err := client.Secrets.KvV2Write(context.Background(), tokenKvPath, schema.KvV2WriteRequest{
-			Data: map[string]interface{}{
-				"token": batchToken,
-			},
+		_, err := client.Secrets.KvV2Write(context.Background(), path, schema.KvV2WriteRequest{
+			Data: record,
 		}, vault.WithMountPath(tokenKvMount))
 		if err != nil {
-			return fmt.Errorf("error storing token at %s: %w", tokenKvPath, err)
+			return fmt.Errorf("error storing token at %s: %w", path, err)
 		}
 	} else {
-		_, err := client.Secrets.KvV1Write(context.Background(), tokenKvPath, map[string]interface{}{
-			"token": batchToken,
-		})
+		_, err := client.Secrets.KvV1Write(context.Background(), path, record, vault.WithMountPath(tokenKvMount))
 		if err != nil {
-			return fmt.Errorf("error storing token at %s: %w", tokenKvPath, err)
+			return fmt.Errorf("error storing token at %s: %w", path, err)
 		}
 	}
-	log.Printf("Token stored at %s/%s", tokenKvMount, tokenKvPath)
+	log.Printf("Token stored at %s/%s", tokenKvMount, path)
 
 	return nil
 }
 
-// Create a token with the handler policy
-func createToken(client *vault.Client, creatorName string) (string, error) {
-	var ttl string
-	fmt.Print("Token TTL: ")
-	fmt.Scan(&ttl)
+// Read the operations token record from the KV engine at the given path
+func readTokenRecord(kvVersion string, client *vault.Client, tokenKvMount string, path string) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if kvVersion == "2" {
+		resp, err := client.Secrets.KvV2Read(context.Background(), path, vault.WithMountPath(tokenKvMount))
+		if err != nil {
+			return nil, fmt.Errorf("error reading token at %s: %w", path, err)
+		}
+		data = resp.Data.Data
+	} else {
+		resp, err := client.Secrets.KvV1Read(context.Background(), path, vault.WithMountPath(tokenKvMount))
+		if err != nil {
+			return nil, fmt.Errorf("error reading token at %s: %w", path, err)
+		}
+		data = resp.Data
+	}
+
+	if token, ok := data["token"].(string); !ok || token == "" {
+		return nil, fmt.Errorf("no token found at %s/%s", tokenKvMount, path)
+	}
+	return data, nil
+}
+
+// Read the operations token from the KV engine at the given path
+func readToken(kvVersion string, client *vault.Client, tokenKvMount string, path string) (string, error) {
+	record, err := readTokenRecord(kvVersion, client, tokenKvMount, path)
+	if err != nil {
+		return "", err
+	}
+	return record["token"].(string), nil
+}
+
+// Delete the operations token record at the given path, including every
+// version of it in a KV v2 engine
+func deleteToken(kvVersion string, client *vault.Client, tokenKvMount string, path string) error {
+	var err error
+	if kvVersion == "2" {
+		_, err = client.Secrets.KvV2DeleteMetadataAndAllVersions(context.Background(), path, vault.WithMountPath(tokenKvMount))
+	} else {
+		_, err = client.Secrets.KvV1Delete(context.Background(), path, vault.WithMountPath(tokenKvMount))
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting token at %s: %w", path, err)
+	}
+	log.Printf("Token deleted from %s/%s", tokenKvMount, path)
+	return nil
+}
+
+// Return the token used to read the operations token from the KV engine: a
+// token obtained with the configured auth method, or $VAULT_TOKEN
+func (c *ConfigData) kvReaderToken(addr string) (string, error) {
+	if c.ClientConfig.authenticator != nil {
+		return c.tokenFor(addr)
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return "", fmt.Errorf("reading the operation batch token from KV requires -authMethod or $VAULT_TOKEN")
+	}
+	return token, nil
+}
+
+// Load the operations token from the KV engine on the first reachable cluster
+// that serves reads: primaries first, then performance secondaries, to which KV
+// data replicates. DR secondaries do not serve reads and are skipped.
+func (c *ConfigData) loadOpBatchToken(addrs []string) error {
+	snapshots, err := c.getCombinedStatus(addrs)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for _, role := range []string{"primary", "secondary"} {
+		for _, snapshot := range snapshots {
+			if snapshot.Dr.Mode == "secondary" {
+				continue
+			}
+			mode := snapshot.Dr.Mode
+			if c.ClientConfig.Mode == "performance" || (c.ClientConfig.Mode == "combined" && replicationEnabled(snapshot.Performance.Mode)) {
+				mode = snapshot.Performance.Mode
+			}
+			if mode == role {
+				candidates = append(candidates, snapshot.Addr)
+			}
+		}
+	}
+
+	for _, addr := range candidates {
+		token, err := c.kvReaderToken(addr)
+		if err != nil {
+			return err
+		}
+		client, err := c.newClient(addr)
+		if err != nil {
+			return err
+		}
+		client.SetToken(token)
+
+		kvVersion, err := verifyKvEngine(client, c.TokenKvMount)
+		if err == nil {
+			token, err = readToken(kvVersion, client, c.TokenKvMount, tokenKvPath)
+		}
+		if err != nil {
+			log.Printf("WARN: could not read operation batch token from %s: %v", addr, err)
+			continue
+		}
+		c.ClientConfig.OpBatchToken = token
+		log.Printf("Loaded operation batch token from %s/%s on %s", c.TokenKvMount, tokenKvPath, addr)
+
+		if c.ClientConfig.Mode == "performance" {
+			c.loadClusterTokens(client, kvVersion, snapshots)
+		}
+		return nil
+	}
+	return fmt.Errorf("could not read the operation batch token from any cluster")
+}
+
+// Load the operations tokens of the performance secondaries, stored under each
+// cluster's name, unless a token was given for the cluster. The token map is
+// copied first, as it is shared with other copies of the configuration.
+func (c *ConfigData) loadClusterTokens(client *vault.Client, kvVersion string, snapshots []combinedSnapshot) {
+	tokens := tokenMap{}
+	for key, token := range c.ClientConfig.OpBatchTokens {
+		tokens[key] = token
+	}
+	c.ClientConfig.OpBatchTokens = tokens
+
+	httpClient := c.getHttpClient()
+	for _, snapshot := range snapshots {
+		if snapshot.Performance.Mode != "secondary" {
+			continue
+		}
+		var health struct {
+			ClusterName string `json:"cluster_name"`
+		}
+		_, err := getJson(httpClient, snapshot.Addr, "/sys/health", "", &health)
+		if err != nil || health.ClusterName == "" {
+			log.Printf("WARN: could not read cluster name for %s: %v", snapshot.Addr, err)
+			continue
+		}
+		if _, ok := tokens[health.ClusterName]; ok {
+			continue
+		}
+		if _, ok := tokens[snapshot.Addr]; ok {
+			continue
+		}
+
+		path := tokenKvPath + "/" + health.ClusterName
+		token, err := readToken(kvVersion, client, c.TokenKvMount, path)
+		if err != nil {
+			log.Printf("WARN: could not read operation batch token for %s: %v", health.ClusterName, err)
+			continue
+		}
+		tokens[health.ClusterName] = token
+		log.Printf("Loaded operation batch token for %s from %s/%s", health.ClusterName, c.TokenKvMount, path)
+	}
+}
+
+// Create a token with the handler policy, prompting for the TTL if none is given
+func createToken(client *vault.Client, creatorName string, ttl string) (string, error) {
+	if ttl == "" {
+		if !stdinIsTerminal() {
+			return "", fmt.Errorf("a token TTL is required and stdin is not a terminal - set -ttl")
+		}
+		fmt.Print("Token TTL: ")
+		fmt.Scan(&ttl)
+	}
 
 	tokenResp, err := client.Auth.TokenCreate(context.Background(), schema.TokenCreateRequest{
 		Type:            "batch",
@@ -179,60 +368,231 @@ func verifyPolicy(client *vault.Client) error {
 	return nil
 }
 
-// Generate an operations batch token
+// Generate an operations batch token on the primary cluster and, in
+// performance mode, on each performance secondary, as each has its own token
+// store. Tokens for performance secondaries are stored under the cluster's name.
 func generateOpBatchToken(c *ConfigData) error {
-	var dec string
-	fmt.Print("Proceeed with batch token generation? [y/n]: ")
-	fmt.Scan(&dec)
+	if c.ClientConfig.Unattended {
+		return fmt.Errorf("operation batch token generation requires an interactive operator")
+	}
 
-	if dec != "y" {
-		log.Fatalln("Operation aborted")
-		os.Exit(1)
+	if !c.ClientConfig.Generate.Confirmed {
+		if !stdinIsTerminal() {
+			return fmt.Errorf("batch token generation must be confirmed and stdin is not a terminal - set -confirm")
+		}
+		var dec string
+		fmt.Print("Proceeed with batch token generation? [y/n]: ")
+		fmt.Scan(&dec)
+
+		if dec != "y" {
+			log.Fatalln("Operation aborted")
+			os.Exit(1)
+		}
 	}
 
 	if !c.PrimaryCluster.Healthy {
 		return fmt.Errorf("primary cluster is not healthy - cannot generate operation batch token")
 	}
 
-	log.Println("A token with suitable policy is required to proceed")
+	err := c.generateClusterToken(&c.PrimaryCluster, tokenKvPath, true)
+	if err != nil {
+		return err
+	}
+
+	var pairs []string
+	if c.ClientConfig.Mode == "performance" {
+		for _, secondary := range c.secondaries() {
+			if !secondary.Healthy {
+				log.Printf("WARN: skipping token generation for unhealthy cluster %s", secondary.Addr)
+				continue
+			}
+			err = c.generateClusterToken(secondary, tokenKvPath+"/"+secondary.Name, false)
+			if err != nil {
+				return err
+			}
+			pairs = append(pairs, secondary.Name+"=<token>")
+		}
+	}
+
+	if len(pairs) > 0 {
+		fmt.Printf("Retrieve the new tokens from the KV engine, then run this with the `opBatchToken` flag set to the primary's token and the `opBatchTokens` flag set to %s\n", strings.Join(pairs, ","))
+	} else {
+		fmt.Println("Retrieve the new token from the KV engine, then run this with the `opBatchToken` flag set to the new token, or with the `opBatchTokenFromKV` flag to read it from the KV engine")
+	}
+
+	return nil
+}
+
+// Privileged token files keyed by cluster address or name, set from a
+// comma-separated list of key=file pairs
+type fileMap map[string]string
+
+func (m *fileMap) String() string {
+	var pairs []string
+	for key, file := range *m {
+		pairs = append(pairs, key+"="+file)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *fileMap) Set(value string) error {
+	if *m == nil {
+		*m = fileMap{}
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, file, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || file == "" {
+			return fmt.Errorf("invalid cluster token file %q - expected <address or cluster name>=<file>", pair)
+		}
+		(*m)[key] = file
+	}
+	return nil
+}
+
+// Report whether stdin is a terminal from which the operator can be prompted
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Return the privileged token used to generate an operations batch token on a
+// cluster: the configured token, or one entered by the operator.
+func (c *ConfigData) privilegedToken(cluster *ClusterData) (string, error) {
+	token, err := c.configuredPrivilegedToken(cluster)
+	if err != nil || token != "" {
+		return token, err
+	}
+
+	if !stdinIsTerminal() {
+		if !c.sharesPrivilegedToken(cluster) {
+			return "", fmt.Errorf("a privileged token for %s is required and stdin is not a terminal - set -privilegedTokenFiles for the cluster", cluster.Addr)
+		}
+		return "", fmt.Errorf("a privileged token is required and stdin is not a terminal - set -privilegedTokenFile or $VAULT_TOKEN")
+	}
+	log.Printf("A token with suitable policy on %s (%s) is required to proceed", cluster.Name, cluster.Addr)
 	fmt.Print("Vault token: ")
-	token, err := term.ReadPassword(0)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
 	if err != nil {
-		return fmt.Errorf("error reading token: %v", err)
+		return "", fmt.Errorf("error reading token: %v", err)
+	}
+	return string(b), nil
+}
+
+// Report whether a cluster uses the shared privileged token. Performance
+// secondaries have their own token store, so the shared token is only used for
+// the primary in performance mode.
+func (c *ConfigData) sharesPrivilegedToken(cluster *ClusterData) bool {
+	return cluster == &c.PrimaryCluster || c.ClientConfig.Mode != "performance"
+}
+
+// Return the privileged token configured for a cluster: read from the
+// cluster's -privilegedTokenFiles entry, or, for a cluster that shares it,
+// -privilegedTokenFile or $VAULT_TOKEN. A response-wrapped Vault Agent sink is
+// unwrapped on the cluster. An empty token is returned when none is configured.
+func (c *ConfigData) configuredPrivilegedToken(cluster *ClusterData) (string, error) {
+	g := &c.ClientConfig.Generate
+	shared := c.sharesPrivilegedToken(cluster)
+	file, ok := g.PrivilegedTokenFiles[cluster.Addr]
+	if !ok && cluster.Name != "" {
+		file, ok = g.PrivilegedTokenFiles[cluster.Name]
+	}
+	if !ok && shared {
+		file = g.PrivilegedTokenFile
 	}
 
-	client, err := c.buildClient(c.PrimaryCluster.Addr, string(token))
+	if file != "" {
+		if token, ok := g.unwrapped[file]; ok {
+			return token, nil
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading privileged token file: %w", err)
+		}
+		contents := strings.TrimSpace(string(b))
+		if !strings.HasPrefix(contents, "{") {
+			return contents, nil
+		}
+		token, err := c.unwrapSinkToken(cluster.Addr, contents)
+		if err != nil {
+			return "", err
+		}
+		if g.unwrapped == nil {
+			g.unwrapped = map[string]string{}
+		}
+		g.unwrapped[file] = token
+		return token, nil
+	}
+	if shared {
+		return os.Getenv("VAULT_TOKEN"), nil
+	}
+	return "", nil
+}
+
+// Unwrap the token written by a Vault Agent file sink configured with
+// wrap_ttl, which holds the response-wrapping information as JSON
+func (c *ConfigData) unwrapSinkToken(addr string, contents string) (string, error) {
+	var wrapInfo struct {
+		Token string `json:"token"`
+	}
+	err := json.Unmarshal([]byte(contents), &wrapInfo)
+	if err != nil || wrapInfo.Token == "" {
+		return "", fmt.Errorf("privileged token file is neither a token nor a response-wrapped token")
+	}
+	client, err := c.buildClient(addr, wrapInfo.Token)
+	if err != nil {
+		return "", fmt.Errorf("build client: %v", err)
+	}
+	resp, err := client.System.Unwrap(context.Background(), schema.UnwrapRequest{})
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping privileged token: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("response-wrapped privileged token file did not contain a token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// Generate an operations batch token on a single cluster using a privileged
+// token, and store it in the KV engine at path. The handler policy is only
+// written on the primary, from which it replicates.
+func (c *ConfigData) generateClusterToken(cluster *ClusterData, path string, primary bool) error {
+	token, err := c.privilegedToken(cluster)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.buildClient(cluster.Addr, token)
 	if err != nil {
 		return fmt.Errorf("build client: %v", err)
 	}
 	lookup, err := client.Auth.TokenLookUp(context.Background(), schema.TokenLookUpRequest{
-		Token: string(token),
+		Token: token,
 	})
 	if err != nil {
 		return fmt.Errorf("error querying for token: %w", err)
 	}
 	creatorName := lookup.Data["display_name"].(string)
-	fmt.Println()
 
-	err = verifyPolicy(client)
-	if err != nil {
-		return fmt.Errorf("verifyPolicy: %w", err)
+	if primary {
+		err = verifyPolicy(client)
+		if err != nil {
+			return fmt.Errorf("verifyPolicy: %w", err)
+		}
 	}
 
 	kvVersion, err := verifyKvEngine(client, c.TokenKvMount)
 	if err != nil {
 		return fmt.Errorf("verifyKvEngine: %w", err)
 	}
-	batchToken, err := createToken(client, creatorName)
+	batchToken, err := createToken(client, creatorName, c.ClientConfig.Generate.TTL)
 	if err != nil {
 		return fmt.Errorf("createToken: %w", err)
 	}
-	err = storeToken(kvVersion, client, batchToken, c.TokenKvMount)
+	err = storeToken(kvVersion, client, tokenRecord(batchToken, creatorName), c.TokenKvMount, path)
 	if err != nil {
 		return fmt.Errorf("storeToken: %w", err)
 	}
 
-	fmt.Println("Retrieve the new token from the KV engine, then run this with the `opBatchToken` flag set to the new token")
-
 	return nil
 }
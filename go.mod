@@ -5,6 +5,7 @@ go 1.23.0
 require (
 	github.com/hashicorp/vault-client-go v0.4.3
 	golang.org/x/term v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Return the healthy secondary clusters that are not connected to the primary
+func (c *ConfigData) disconnectedSecondaries() []*ClusterData {
+	var disconnected []*ClusterData
+	for _, secondary := range c.secondaries() {
+		if secondary.Healthy && secondary.Follower && !secondary.Connected {
+			disconnected = append(disconnected, secondary)
+		}
+	}
+	return disconnected
+}
+
+// Return the healthy secondary clusters that report a connection to the primary
+// but whose heartbeats from it are stale
+func (c *ConfigData) staleSecondaries() []*ClusterData {
+	var stale []*ClusterData
+	for _, secondary := range c.secondaries() {
+		if secondary.Healthy && secondary.Follower && secondary.Connected && secondary.StaleHeartbeat {
+			stale = append(stale, secondary)
+		}
+	}
+	return stale
+}
+
+// Build the ordered steps required to re-establish replication between a
+// healthy primary and each of the given secondaries, by revoking the existing
+// secondary activation token and updating the secondary with a newly-issued one
+func (c *ConfigData) healSteps(secondaries []*ClusterData) []step {
+	var steps []step
+	for _, secondary := range secondaries {
+		steps = append(steps,
+			c.revokeSecondaryStep(&c.PrimaryCluster, secondary),
+			c.activationTokenStep(&c.PrimaryCluster, secondary),
+			c.updatePrimaryStep(secondary),
+		)
+	}
+	return steps
+}
+
+// Heal replication between the primary and any disconnected secondary clusters
+func (c *ConfigData) heal() error {
+	if c.PrimaryCluster.Client == nil {
+		return fmt.Errorf("primary cluster client must be initialized to heal replication")
+	}
+	if len(c.disconnectedSecondaries()) == 0 {
+		return fmt.Errorf("no healthy disconnected secondary clusters found")
+	}
+
+	return c.execute(c.healSteps(c.disconnectedSecondaries()))
+}
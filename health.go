@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The replication health checks made against each replication peer
+const (
+	healthClockSkew      = "clock-skew"
+	healthStaleHeartbeat = "stale-heartbeat"
+	healthCanaryAge      = "canary-age"
+)
+
+// A replication health problem reported by a cluster about one of its peers
+type healthIssue struct {
+	Check  string `json:"check" yaml:"check"`
+	Peer   string `json:"peer" yaml:"peer"`
+	Detail string `json:"detail" yaml:"detail"`
+}
+
+// Parse a duration reported by Vault as a string of milliseconds
+func parseMs(ms string) (time.Duration, bool) {
+	v, err := strconv.ParseFloat(ms, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(v * float64(time.Millisecond)), true
+}
+
+// Evaluate the health of each replication peer reported by a cluster, relative
+// to the time of discovery: excessive clock skew, heartbeats older than the
+// stale threshold, and replication canaries older than the maximum age
+func (c *ConfigData) healthIssues(snapshot ClusterSnapshot) []healthIssue {
+	var status replicationStatus
+	if len(snapshot.Replication) == 0 || json.Unmarshal(snapshot.Replication, &status) != nil {
+		return nil
+	}
+
+	limits := c.ClientConfig.Health
+	var issues []healthIssue
+	for _, peer := range append(status.Primaries, status.Secondaries...) {
+		addr := peer.APIAddress
+		if addr == "" {
+			addr = peer.ClusterAddress
+		}
+		if skew, ok := parseMs(peer.ClockSkewMs); ok && limits.MaxClockSkew > 0 && time.Duration(math.Abs(float64(skew))) > limits.MaxClockSkew {
+			issues = append(issues, healthIssue{healthClockSkew, addr, fmt.Sprintf("clock skew of %s exceeds %s", skew, limits.MaxClockSkew)})
+		}
+		if !peer.LastHeartbeat.IsZero() && limits.StaleHeartbeat > 0 {
+			age := c.Topology.DiscoveredAt.Sub(peer.LastHeartbeat)
+			if age > limits.StaleHeartbeat {
+				detail := fmt.Sprintf("last heartbeat %s ago exceeds %s", age.Round(time.Second), limits.StaleHeartbeat)
+				if took, ok := parseMs(peer.LastHeartbeatDurationMs); ok {
+					detail += fmt.Sprintf(" (took %s)", took)
+				}
+				issues = append(issues, healthIssue{healthStaleHeartbeat, addr, detail})
+			}
+		}
+		if age, ok := parseMs(peer.ReplicationPrimaryCanaryAgeMs); ok && limits.MaxCanaryAge > 0 && age > limits.MaxCanaryAge {
+			issues = append(issues, healthIssue{healthCanaryAge, addr, fmt.Sprintf("replication canary age of %s exceeds %s", age, limits.MaxCanaryAge)})
+		}
+	}
+	return issues
+}
+
+// Report whether any of the issues is the result of the given check
+func hasIssue(issues []healthIssue, check string) bool {
+	for _, issue := range issues {
+		if issue.Check == check {
+			return true
+		}
+	}
+	return false
+}
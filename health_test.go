@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthIssues(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limits := HealthConfig{MaxClockSkew: 10 * time.Second, StaleHeartbeat: 30 * time.Second, MaxCanaryAge: time.Minute}
+
+	tests := []struct {
+		name   string
+		limits HealthConfig
+		peers  []replicationPeer
+		want   []string
+	}{
+		{
+			name:   "healthy peer",
+			limits: limits,
+			peers:  []replicationPeer{{APIAddress: "https://a:8200", LastHeartbeat: now.Add(-5 * time.Second), ClockSkewMs: "120", ReplicationPrimaryCanaryAgeMs: "800"}},
+			want:   nil,
+		},
+		{
+			name:   "negative clock skew",
+			limits: limits,
+			peers:  []replicationPeer{{APIAddress: "https://a:8200", LastHeartbeat: now, ClockSkewMs: "-15000"}},
+			want:   []string{healthClockSkew},
+		},
+		{
+			name:   "stale heartbeat",
+			limits: limits,
+			peers:  []replicationPeer{{APIAddress: "https://a:8200", LastHeartbeat: now.Add(-time.Minute)}},
+			want:   []string{healthStaleHeartbeat},
+		},
+		{
+			name:   "old canary",
+			limits: limits,
+			peers:  []replicationPeer{{APIAddress: "https://a:8200", LastHeartbeat: now, ReplicationPrimaryCanaryAgeMs: "90000"}},
+			want:   []string{healthCanaryAge},
+		},
+		{
+			name:   "every check on every peer",
+			limits: limits,
+			peers: []replicationPeer{
+				{ClusterAddress: "https://a:8201", LastHeartbeat: now.Add(-time.Minute), ClockSkewMs: "20000", ReplicationPrimaryCanaryAgeMs: "90000"},
+				{APIAddress: "https://b:8200", LastHeartbeat: now.Add(-time.Minute)},
+			},
+			want: []string{healthClockSkew, healthStaleHeartbeat, healthCanaryAge, healthStaleHeartbeat},
+		},
+		{
+			name:  "checks disabled",
+			peers: []replicationPeer{{APIAddress: "https://a:8200", LastHeartbeat: now.Add(-time.Hour), ClockSkewMs: "20000", ReplicationPrimaryCanaryAgeMs: "90000"}},
+			want:  nil,
+		},
+		{
+			name:   "unparseable durations and no heartbeat",
+			limits: limits,
+			peers:  []replicationPeer{{APIAddress: "https://a:8200", ClockSkewMs: "n/a"}},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := json.Marshal(replicationStatus{Primaries: tt.peers})
+			c := ConfigData{
+				ClientConfig: ClientConfig{Health: tt.limits},
+				Topology:     Topology{DiscoveredAt: now},
+			}
+			var got []string
+			for _, issue := range c.healthIssues(ClusterSnapshot{Replication: b}) {
+				got = append(got, issue.Check)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("healthIssues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthIssuesPeerAddress(t *testing.T) {
+	b, _ := json.Marshal(replicationStatus{Secondaries: []replicationPeer{{ClusterAddress: "https://b:8201", ClockSkewMs: "20000"}}})
+	c := ConfigData{ClientConfig: ClientConfig{Health: HealthConfig{MaxClockSkew: time.Second}}}
+	issues := c.healthIssues(ClusterSnapshot{Replication: b})
+	if len(issues) != 1 || issues[0].Peer != "https://b:8201" {
+		t.Errorf("healthIssues() = %+v, want one issue for the peer's cluster address", issues)
+	}
+}
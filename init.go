@@ -10,11 +10,41 @@ import (
 
 // Initialize vault clients for primary and secondary clusters
 func (c *ConfigData) initialize() {
-	c.OpBatchTokenVerified = false
-	c.OpBatchTokenValid = false
-	err := c.getTopology(c.ClientConfig.VerifiedAddrs)
+	err := c.refresh()
 	if err != nil {
-		log.Fatalf("error getting topology: %v", err)
+		log.Fatalf("%v", err)
+	}
+}
+
+// Return a copy of the configuration without any discovered state, for use in
+// a fresh round of discovery
+func (c *ConfigData) fresh() ConfigData {
+	return ConfigData{
+		ClientConfig: c.ClientConfig,
+		TokenKvMount: c.TokenKvMount,
+	}
+}
+
+// Verify the configured addresses, discover the topology and initialize vault
+// clients for the primary and secondary clusters
+func (c *ConfigData) refresh() error {
+	err := c.ClientConfig.verifyAddrs()
+	if err != nil {
+		return err
+	}
+	if c.ClientConfig.OpBatchTokenFromKV {
+		err := c.loadOpBatchToken(c.ClientConfig.VerifiedAddrs)
+		if err != nil {
+			log.Printf("WARN: %v", err)
+		}
+	}
+	t, err := c.getTopology(c.ClientConfig.VerifiedAddrs)
+	if err != nil {
+		return fmt.Errorf("error getting topology: %w", err)
+	}
+	err = c.applyTopology(t)
+	if err != nil {
+		return fmt.Errorf("error applying topology: %w", err)
 	}
 
 	for _, addr := range c.ClientConfig.VerifiedAddrs {
@@ -25,18 +55,39 @@ func (c *ConfigData) initialize() {
 	}
 
 	if c.PrimaryCluster.Client == nil && c.SecondaryCluster.Client == nil {
-		log.Fatalln("could not initialize clients for primary and secondary clusters")
+		return fmt.Errorf("could not initialize clients for primary and secondary clusters")
 	}
+	return nil
 }
 
 // Build a vault client for a given address
-// If a token is not provided, the client will use the operation batch token
+// If a token is not provided, the client will use the operation batch token or
+// a token obtained with the configured auth method
 func (c *ConfigData) buildClient(addr string, token string) (*vault.Client, error) {
 	if token == "" {
-		token = c.ClientConfig.OpBatchToken
+		var err error
+		token, err = c.tokenFor(addr)
+		if err != nil {
+			log.Printf("WARN: %v", err)
+		}
+	}
+	client, err := c.newClient(addr)
+	if err != nil {
+		return nil, err
 	}
+
+	client.SetToken(token)
+	return client, nil
+}
+
+// Build an unauthenticated vault client for a given address
+func (c *ConfigData) newClient(addr string) (*vault.Client, error) {
 	tls := vault.TLSConfiguration{}
 	tls.InsecureSkipVerify = c.ClientConfig.TlsSkipVerify
+	if c.ClientConfig.Auth.Method == "cert" {
+		tls.ClientCertificate.FromFile = c.ClientConfig.Auth.CertFile
+		tls.ClientCertificateKey.FromFile = c.ClientConfig.Auth.KeyFile
+	}
 	client, err := vault.New(
 		vault.WithAddress(addr),
 		vault.WithRequestTimeout(timeout),
@@ -46,20 +97,47 @@ func (c *ConfigData) buildClient(addr string, token string) (*vault.Client, erro
 	if err != nil {
 		return nil, fmt.Errorf("error initializing client for %s: %w", addr, err)
 	}
-
-	client.SetToken(token)
 	return client, nil
 }
 
-// Initialize a Vault client and verify the health status of the cluster
-func (c *ConfigData) initClient(addr string) error {
-	var repMode string
+// Return the cluster assigned to an address along with its replication role
+func (c *ConfigData) clusterByAddr(addr string) (*ClusterData, string) {
 	switch addr {
 	case c.PrimaryCluster.Addr:
-		repMode = "primary"
+		return &c.PrimaryCluster, "primary"
 	case c.SecondaryCluster.Addr:
-		repMode = "secondary"
-	default:
+		return &c.SecondaryCluster, "secondary"
+	}
+	for i := range c.AdditionalSecondaries {
+		if c.AdditionalSecondaries[i].Addr == addr {
+			return &c.AdditionalSecondaries[i], "secondary"
+		}
+	}
+	return nil, ""
+}
+
+// Return the additional secondary clusters
+func (c *ConfigData) additionalSecondaries() []*ClusterData {
+	var secondaries []*ClusterData
+	for i := range c.AdditionalSecondaries {
+		secondaries = append(secondaries, &c.AdditionalSecondaries[i])
+	}
+	return secondaries
+}
+
+// Return the secondary cluster followed by any additional secondary clusters
+func (c *ConfigData) secondaries() []*ClusterData {
+	var secondaries []*ClusterData
+	if c.SecondaryCluster.Addr != "" {
+		secondaries = append(secondaries, &c.SecondaryCluster)
+	}
+	return append(secondaries, c.additionalSecondaries()...)
+}
+
+// Initialize a Vault client and verify the health status of the cluster
+func (c *ConfigData) initClient(addr string) error {
+	cluster, repMode := c.clusterByAddr(addr)
+	if cluster == nil {
 		return fmt.Errorf("could not determine replication mode for %s - aborting", addr)
 	}
 
@@ -81,17 +159,10 @@ func (c *ConfigData) initClient(addr string) error {
 		return err
 	}
 
-	if addr == c.PrimaryCluster.Addr {
-		c.PrimaryCluster.Client = client
-		c.PrimaryCluster.Healthy = true
-		c.PrimaryCluster.Name = healthResp.Data["cluster_name"].(string)
-		c.PrimaryCluster.ClusterAddr = leaderResp.Data.LeaderClusterAddress
-	} else {
-		c.SecondaryCluster.Client = client
-		c.SecondaryCluster.Healthy = true
-		c.SecondaryCluster.Name = healthResp.Data["cluster_name"].(string)
-		c.SecondaryCluster.ClusterAddr = leaderResp.Data.LeaderClusterAddress
-	}
+	cluster.Client = client
+	cluster.Healthy = true
+	cluster.Name = healthResp.Data["cluster_name"].(string)
+	cluster.ClusterAddr = leaderResp.Data.LeaderClusterAddress
 	log.Printf("Initialized client for %s (%s %s)", addr, c.ClientConfig.Mode, repMode)
 
 	return nil
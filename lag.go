@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+)
+
+// How far a secondary cluster trails its primary
+type replicationLag struct {
+	walDelta      int
+	walKnown      bool
+	lastHeartbeat time.Time
+	heartbeatAge  time.Duration
+}
+
+// The names of the replication lag limits, as given to -allowDataLoss
+const (
+	lagLimitWalDelta     = "maxWalDelta"
+	lagLimitHeartbeatAge = "maxHeartbeatAge"
+)
+
+// A replication lag limit that a secondary is beyond, or that cannot be
+// evaluated
+type lagViolation struct {
+	limit  string
+	reason string
+}
+
+// Join the reasons for a list of lag violations
+func lagReasons(violations []lagViolation) string {
+	var reasons []string
+	for _, v := range violations {
+		reasons = append(reasons, v.reason)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// The lag limits waived for a forced promotion, set from a comma-separated list
+// of limit names. Given without a value, every limit is waived.
+type lagWaiver map[string]bool
+
+func (w *lagWaiver) String() string {
+	var limits []string
+	for limit := range *w {
+		limits = append(limits, limit)
+	}
+	sort.Strings(limits)
+	return strings.Join(limits, ",")
+}
+
+func (w *lagWaiver) Set(value string) error {
+	*w = lagWaiver{}
+	switch value {
+	case "false":
+		return nil
+	case "true":
+		value = lagLimitWalDelta + "," + lagLimitHeartbeatAge
+	}
+	for _, limit := range strings.Split(value, ",") {
+		if limit != lagLimitWalDelta && limit != lagLimitHeartbeatAge {
+			return fmt.Errorf("invalid lag limit %q - expected %s or %s", limit, lagLimitWalDelta, lagLimitHeartbeatAge)
+		}
+		(*w)[limit] = true
+	}
+	return nil
+}
+
+func (w *lagWaiver) IsBoolFlag() bool {
+	return true
+}
+
+// Describe the lag, noting any measurement that is unavailable
+func (l replicationLag) String() string {
+	wal := "WAL delta unknown"
+	if l.walKnown {
+		wal = fmt.Sprintf("WAL delta %d", l.walDelta)
+	}
+	heartbeat := "no heartbeat from the primary"
+	if !l.lastHeartbeat.IsZero() {
+		heartbeat = fmt.Sprintf("last heartbeat from the primary %s ago (%s)", l.heartbeatAge.Round(time.Second), l.lastHeartbeat.Format(time.RFC3339))
+	}
+	return wal + ", " + heartbeat
+}
+
+// Return the most recent heartbeat a secondary received from a primary
+func lastHeartbeat(status replicationStatus) time.Time {
+	var last time.Time
+	for _, peer := range status.Primaries {
+		if peer.LastHeartbeat.After(last) {
+			last = peer.LastHeartbeat
+		}
+	}
+	return last
+}
+
+// Measure the lag of a secondary cluster from the discovered topology. The WAL
+// delta is only known for the selected secondary while its primary's
+// replication status is available.
+func (c *ConfigData) discoveredLag(cluster *ClusterData, now time.Time) replicationLag {
+	var lag replicationLag
+	if cluster == &c.SecondaryCluster {
+		lag.walDelta, lag.walKnown = c.walDelta()
+	}
+	for _, snapshot := range c.Topology.Clusters {
+		var status replicationStatus
+		if snapshot.Addr != cluster.Addr || json.Unmarshal(snapshot.Replication, &status) != nil {
+			continue
+		}
+		lag.lastHeartbeat = lastHeartbeat(status)
+	}
+	if !lag.lastHeartbeat.IsZero() {
+		lag.heartbeatAge = now.Sub(lag.lastHeartbeat)
+	}
+	return lag
+}
+
+// Read the replication status of a cluster for the given mode
+func readReplicationStatus(client *vault.Client, mode string) ([]byte, error) {
+	resp, err := client.System.ReadReplicationStatus(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error reading replication status: %w", err)
+	}
+	status, ok := resp.Data[mode]
+	if !ok {
+		return nil, fmt.Errorf("replication status does not include %s replication", mode)
+	}
+	return json.Marshal(status)
+}
+
+// Read the current replication status of the primary and secondary clusters
+// and measure the lag between them
+func (c *ConfigData) currentLag() (replicationLag, error) {
+	primary, err := readReplicationStatus(c.PrimaryCluster.Client, c.ClientConfig.Mode)
+	if err != nil {
+		return replicationLag{}, err
+	}
+	secondary, err := readReplicationStatus(c.SecondaryCluster.Client, c.ClientConfig.Mode)
+	if err != nil {
+		return replicationLag{}, err
+	}
+
+	switch c.ClientConfig.Mode {
+	case "dr":
+		err = json.Unmarshal(primary, &c.PrimaryDrConfig)
+		if err == nil {
+			err = json.Unmarshal(secondary, &c.SecondaryDrConfig)
+		}
+	case "performance":
+		err = json.Unmarshal(primary, &c.PrimaryPrConfig)
+		if err == nil {
+			err = json.Unmarshal(secondary, &c.SecondaryPrConfig)
+		}
+	}
+	var status replicationStatus
+	if err == nil {
+		err = json.Unmarshal(secondary, &status)
+	}
+	if err != nil {
+		return replicationLag{}, fmt.Errorf("failed to unmarshal replication status: %w", err)
+	}
+
+	lag := replicationLag{lastHeartbeat: lastHeartbeat(status)}
+	lag.walDelta, lag.walKnown = c.walDelta()
+	if !lag.lastHeartbeat.IsZero() {
+		lag.heartbeatAge = time.Since(lag.lastHeartbeat)
+	}
+	return lag, nil
+}
+
+// Return the ways in which the lag exceeds the configured maximums. A configured
+// maximum that cannot be evaluated, such as the WAL delta while the primary is
+// unavailable, counts as exceeded.
+func (c *ConfigData) lagExceeded(lag replicationLag) []lagViolation {
+	limits := c.ClientConfig.Lag
+	var exceeded []lagViolation
+	if limits.MaxWalDelta > 0 {
+		if !lag.walKnown {
+			exceeded = append(exceeded, lagViolation{lagLimitWalDelta, "WAL delta cannot be measured without the primary's replication status"})
+		} else if lag.walDelta > limits.MaxWalDelta {
+			exceeded = append(exceeded, lagViolation{lagLimitWalDelta, fmt.Sprintf("WAL delta %d exceeds the maximum of %d", lag.walDelta, limits.MaxWalDelta)})
+		}
+	}
+	if limits.MaxHeartbeatAge > 0 {
+		if lag.lastHeartbeat.IsZero() {
+			exceeded = append(exceeded, lagViolation{lagLimitHeartbeatAge, "no heartbeat from the primary has been recorded"})
+		} else if lag.heartbeatAge > limits.MaxHeartbeatAge {
+			exceeded = append(exceeded, lagViolation{lagLimitHeartbeatAge, fmt.Sprintf("heartbeat age %s exceeds the maximum of %s", lag.heartbeatAge.Round(time.Second), limits.MaxHeartbeatAge)})
+		}
+	}
+	return exceeded
+}
+
+// Report whether a maximum replication lag is configured
+func (c *ConfigData) lagLimited() bool {
+	return c.ClientConfig.Lag.MaxWalDelta > 0 || c.ClientConfig.Lag.MaxHeartbeatAge > 0
+}
+
+// Describe the configured maximum replication lag
+func (c *ConfigData) lagLimitDescription() string {
+	var limits []string
+	if c.ClientConfig.Lag.MaxWalDelta > 0 {
+		limits = append(limits, fmt.Sprintf("a WAL delta of %d", c.ClientConfig.Lag.MaxWalDelta))
+	}
+	if c.ClientConfig.Lag.MaxHeartbeatAge > 0 {
+		limits = append(limits, fmt.Sprintf("a heartbeat age of %s", c.ClientConfig.Lag.MaxHeartbeatAge))
+	}
+	return strings.Join(limits, " and ")
+}
+
+// Wait for the secondary cluster to catch up with the primary before a planned
+// failover, so that no data is lost when the primary is demoted. Nothing has
+// been changed if the wait times out.
+func (c *ConfigData) catchUpStep() step {
+	return step{
+		Description: fmt.Sprintf("Wait up to %s for %s to be within %s of %s", c.ClientConfig.Lag.WaitTimeout, c.SecondaryCluster.Addr, c.lagLimitDescription(), c.PrimaryCluster.Addr),
+		run: func() error {
+			deadline := time.Now().Add(c.ClientConfig.Lag.WaitTimeout)
+			for {
+				lag, err := c.currentLag()
+				if err != nil {
+					return fmt.Errorf("wait for secondary to catch up: %w", err)
+				}
+				exceeded := c.lagExceeded(lag)
+				if len(exceeded) == 0 {
+					log.Printf("Secondary has caught up with the primary: %s", lag)
+					return nil
+				}
+				if time.Now().After(deadline) {
+					return fmt.Errorf("secondary did not catch up with the primary within %s: %s", c.ClientConfig.Lag.WaitTimeout, lagReasons(exceeded))
+				}
+				log.Printf("Waiting for secondary to catch up: %s", lagReasons(exceeded))
+				time.Sleep(timeout)
+			}
+		},
+	}
+}
+
+// Report the estimated data-loss window before a forced promotion, and refuse
+// to promote beyond any configured maximum lag that has not been explicitly
+// waived. Each limit is waived separately, so that one that cannot be evaluated
+// without the primary does not require waiving the others.
+func (c *ConfigData) lossWindowStep(cluster *ClusterData) step {
+	description := fmt.Sprintf("Report the estimated data-loss window for promoting %s", cluster.Addr)
+	if c.lagLimited() {
+		description += fmt.Sprintf(" and refuse beyond %s unless waived with -allowDataLoss", c.lagLimitDescription())
+	}
+	return step{
+		Description: description,
+		run: func() error {
+			lag := c.discoveredLag(cluster, time.Now())
+			log.Printf("Estimated data-loss window for promoting %s: %s", cluster.Addr, lag)
+
+			var refused, waived []lagViolation
+			var limits []string
+			for _, v := range c.lagExceeded(lag) {
+				if c.ClientConfig.Lag.AllowDataLoss[v.limit] {
+					waived = append(waived, v)
+					continue
+				}
+				refused = append(refused, v)
+				limits = append(limits, v.limit)
+			}
+			if len(refused) > 0 {
+				return fmt.Errorf("refusing to promote %s: %s - set -allowDataLoss=%s to promote anyway", cluster.Addr, lagReasons(refused), strings.Join(limits, ","))
+			}
+			if len(waived) > 0 {
+				log.Printf("WARNING: promoting %s despite replication lag (-allowDataLoss): %s", cluster.Addr, lagReasons(waived))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLagExceeded(t *testing.T) {
+	heartbeat := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		limits LagConfig
+		lag    replicationLag
+		want   []string
+	}{
+		{
+			name: "no limits configured",
+			lag:  replicationLag{},
+			want: nil,
+		},
+		{
+			name:   "within both limits",
+			limits: LagConfig{MaxWalDelta: 100, MaxHeartbeatAge: 5 * time.Minute},
+			lag:    replicationLag{walDelta: 10, walKnown: true, lastHeartbeat: heartbeat, heartbeatAge: time.Minute},
+			want:   nil,
+		},
+		{
+			name:   "WAL delta exceeded",
+			limits: LagConfig{MaxWalDelta: 100},
+			lag:    replicationLag{walDelta: 101, walKnown: true},
+			want:   []string{lagLimitWalDelta},
+		},
+		{
+			name:   "WAL delta unknown with only a WAL limit",
+			limits: LagConfig{MaxWalDelta: 100},
+			lag:    replicationLag{lastHeartbeat: heartbeat, heartbeatAge: time.Minute},
+			want:   []string{lagLimitWalDelta},
+		},
+		{
+			name:   "WAL delta unknown with only a heartbeat limit",
+			limits: LagConfig{MaxHeartbeatAge: 5 * time.Minute},
+			lag:    replicationLag{lastHeartbeat: heartbeat, heartbeatAge: time.Minute},
+			want:   nil,
+		},
+		{
+			name:   "heartbeat too old",
+			limits: LagConfig{MaxHeartbeatAge: 30 * time.Second},
+			lag:    replicationLag{lastHeartbeat: heartbeat, heartbeatAge: time.Minute},
+			want:   []string{lagLimitHeartbeatAge},
+		},
+		{
+			name:   "no heartbeat recorded",
+			limits: LagConfig{MaxHeartbeatAge: 30 * time.Second},
+			lag:    replicationLag{walKnown: true},
+			want:   []string{lagLimitHeartbeatAge},
+		},
+		{
+			name:   "nothing measurable with both limits",
+			limits: LagConfig{MaxWalDelta: 100, MaxHeartbeatAge: 30 * time.Second},
+			lag:    replicationLag{},
+			want:   []string{lagLimitWalDelta, lagLimitHeartbeatAge},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ConfigData{ClientConfig: ClientConfig{Lag: tt.limits}}
+			var got []string
+			for _, v := range c.lagExceeded(tt.lag) {
+				got = append(got, v.limit)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lagExceeded() limits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLagWaiverSet(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      string
+		wantError bool
+	}{
+		{value: "true", want: "maxHeartbeatAge,maxWalDelta"},
+		{value: "false", want: ""},
+		{value: "maxWalDelta", want: "maxWalDelta"},
+		{value: "maxWalDelta,maxHeartbeatAge", want: "maxHeartbeatAge,maxWalDelta"},
+		{value: "maxClockSkew", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var w lagWaiver
+			err := w.Set(tt.value)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Set(%q) error = %v, want error %t", tt.value, err, tt.wantError)
+			}
+			if err == nil && w.String() != tt.want {
+				t.Errorf("Set(%q) = %q, want %q", tt.value, w.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLossWindowWaivesEachLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    LagConfig
+		waive     string
+		wantError bool
+	}{
+		{
+			name:      "unknown WAL delta not waived",
+			limits:    LagConfig{MaxWalDelta: 100},
+			wantError: true,
+		},
+		{
+			name:   "unknown WAL delta waived",
+			limits: LagConfig{MaxWalDelta: 100},
+			waive:  "maxWalDelta",
+		},
+		{
+			name:      "WAL delta waived, heartbeat still enforced",
+			limits:    LagConfig{MaxWalDelta: 100, MaxHeartbeatAge: time.Minute},
+			waive:     "maxWalDelta",
+			wantError: true,
+		},
+		{
+			name:   "every limit waived",
+			limits: LagConfig{MaxWalDelta: 100, MaxHeartbeatAge: time.Minute},
+			waive:  "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ConfigData{
+				ClientConfig:     ClientConfig{Mode: "dr", Lag: tt.limits},
+				SecondaryCluster: ClusterData{Addr: "https://b:8200"},
+			}
+			if tt.waive != "" {
+				if err := c.ClientConfig.Lag.AllowDataLoss.Set(tt.waive); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := c.lossWindowStep(&c.SecondaryCluster).run()
+			if (err != nil) != tt.wantError {
+				t.Errorf("loss window check error = %v, want error %t", err, tt.wantError)
+			}
+		})
+	}
+}
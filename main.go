@@ -1,8 +1,6 @@
 package main
 
 import (
-	"flag"
-	"log"
 	"os"
 	"time"
 
@@ -25,34 +23,99 @@ type ConfigData struct {
 	ClientConfig             ClientConfig      `json:"clientConfig,omitempty"`
 	PrimaryCluster           ClusterData       `json:"primaryClusterData,omitempty"`
 	SecondaryCluster         ClusterData       `json:"secondaryClusterData,omitempty"`
+	AdditionalSecondaries    []ClusterData     `json:"additionalSecondaryClusterData,omitempty"`
 	PrimaryDrConfig          PrimaryDrConfig   `json:"primaryDrConfig,omitempty"`
 	SecondaryDrConfig        SecondaryDrConfig `json:"secondaryDrConfig,omitempty"`
 	PrimaryPrConfig          PrimaryPrConfig   `json:"primaryPrConfig,omitempty"`
 	SecondaryPrConfig        SecondaryPrConfig `json:"secondaryPrConfig,omitempty"`
-	OpBatchTokenValid        bool              `json:"opBatchTokenValid,omitempty"`
-	OpBatchTokenVerified     bool              `json:"opBatchTokenVerified,omitempty"`
 	SecondaryActivationToken string            `json:"secondaryActivationToken,omitempty"`
 	HighestWal               float64           `json:"highestWal,omitempty"`
 	TokenKvMount             string            `json:"tokenKvPath,omitempty"`
+	Topology                 Topology          `json:"topology,omitempty"`
+	Conflict                 string            `json:"conflict,omitempty"`
+	WitnessVotes             []witnessVote     `json:"witnessVotes,omitempty"`
 }
 
 type ClusterData struct {
-	Addr        string        `json:"addr,omitempty"`
-	Name        string        `json:"clusterName,omitempty"`
-	Healthy     bool          `json:"healthy,omitempty"`
-	Leader      bool          `json:"isLeader,omitempty"`
-	Follower    bool          `json:"isFollower,omitempty"`
-	Client      *vault.Client `json:"client,omitempty"`
-	ClusterAddr string        `json:"clusterAddr,omitempty"`
-	Connected   bool          `json:"connected,omitempty"`
+	Addr           string        `json:"addr,omitempty"`
+	Name           string        `json:"clusterName,omitempty"`
+	Healthy        bool          `json:"healthy,omitempty"`
+	Leader         bool          `json:"isLeader,omitempty"`
+	Follower       bool          `json:"isFollower,omitempty"`
+	Client         *vault.Client `json:"-"`
+	ClusterAddr    string        `json:"clusterAddr,omitempty"`
+	Connected      bool          `json:"connected,omitempty"`
+	SecondaryID    string        `json:"secondaryId,omitempty"`
+	TokenValid     bool          `json:"tokenValid,omitempty"`
+	StaleHeartbeat bool          `json:"staleHeartbeat,omitempty"`
 }
 
 type ClientConfig struct {
-	Mode            string   `json:"mode,omitempty"`
-	ConfiguredAddrs string   `json:"configuredAddr,omitempty"`
-	OpBatchToken    string   `json:"opBatchToken,omitempty"`
-	TlsSkipVerify   bool     `json:"tlsSkipVerify,omitempty"`
-	VerifiedAddrs   []string `json:"verifiedAddrs,omitempty"`
+	Mode                         string         `json:"mode,omitempty"`
+	ConfiguredAddrs              string         `json:"configuredAddr,omitempty"`
+	OpBatchToken                 string         `json:"opBatchToken,omitempty"`
+	OpBatchTokenFromKV           bool           `json:"opBatchTokenFromKV,omitempty"`
+	OpBatchTokens                tokenMap       `json:"-"`
+	TlsSkipVerify                bool           `json:"tlsSkipVerify,omitempty"`
+	DryRun                       bool           `json:"dryRun,omitempty"`
+	Unattended                   bool           `json:"unattended,omitempty"`
+	RecoveryPgpKeyFile           string         `json:"recoveryPgpKeyFile,omitempty"`
+	VerifiedAddrs                []string       `json:"verifiedAddrs,omitempty"`
+	MinTokenTTL                  time.Duration  `json:"minTokenTtl,omitempty"`
+	Auth                         AuthConfig     `json:"auth,omitempty"`
+	Generate                     GenerateConfig `json:"generate,omitempty"`
+	Lag                          LagConfig      `json:"lag,omitempty"`
+	Health                       HealthConfig   `json:"health,omitempty"`
+	AllowCorruptedMerkle         bool           `json:"allowCorruptedMerkle,omitempty"`
+	AllowLivePrimary             bool           `json:"allowLivePrimary,omitempty"`
+	AllowUncheckedDrCapabilities bool           `json:"allowUncheckedDrCapabilities,omitempty"`
+	Witness                      WitnessConfig  `json:"witness,omitempty"`
+	authenticator                authenticator
+	tokens                       *tokenCache
+}
+
+type AuthConfig struct {
+	Method       string `json:"method,omitempty"`
+	Mount        string `json:"mount,omitempty"`
+	Role         string `json:"role,omitempty"`
+	RoleID       string `json:"roleId,omitempty"`
+	SecretIDFile string `json:"secretIdFile,omitempty"`
+	JwtFile      string `json:"jwtFile,omitempty"`
+	CertFile     string `json:"certFile,omitempty"`
+	KeyFile      string `json:"keyFile,omitempty"`
+	Username     string `json:"username,omitempty"`
+}
+
+type GenerateConfig struct {
+	TTL                  string  `json:"ttl,omitempty"`
+	PrivilegedTokenFile  string  `json:"privilegedTokenFile,omitempty"`
+	PrivilegedTokenFiles fileMap `json:"privilegedTokenFiles,omitempty"`
+	Confirmed            bool    `json:"confirmed,omitempty"`
+	// privileged tokens unwrapped from Vault Agent sinks, keyed by file, as
+	// each can only be unwrapped once
+	unwrapped map[string]string
+}
+
+type LagConfig struct {
+	MaxWalDelta     int           `json:"maxWalDelta,omitempty"`
+	MaxHeartbeatAge time.Duration `json:"maxHeartbeatAge,omitempty"`
+	WaitTimeout     time.Duration `json:"waitTimeout,omitempty"`
+	AllowDataLoss   lagWaiver     `json:"allowDataLoss,omitempty"`
+}
+
+type HealthConfig struct {
+	MaxClockSkew   time.Duration `json:"maxClockSkew,omitempty"`
+	StaleHeartbeat time.Duration `json:"staleHeartbeat,omitempty"`
+	MaxCanaryAge   time.Duration `json:"maxCanaryAge,omitempty"`
+}
+
+type WitnessConfig struct {
+	Addrs    string `json:"addrs,omitempty"`
+	Quorum   int    `json:"quorum,omitempty"`
+	Token    string `json:"-"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	CAFile   string `json:"caFile,omitempty"`
 }
 
 type DrConfigBase struct {
@@ -148,33 +211,5 @@ type SecondaryPrConfig struct {
 }
 
 func main() {
-	c := ConfigData{}
-	flag.StringVar(&c.ClientConfig.ConfiguredAddrs, "addresses", "https://localhost:8200,https://localhost:8300", "Comma-separated list of two Vault addresses in a replication relationship")
-	flag.StringVar(&c.ClientConfig.OpBatchToken, "opBatchToken", "", "Operation batch token with a policy that allows for the manipulation of replication configurations on either cluster")
-	flag.BoolVar(&c.ClientConfig.TlsSkipVerify, "tlsSkipVerify", false, "Skip TLS verification of the Vault server's certificate")
-	flag.StringVar(&c.ClientConfig.Mode, "mode", "", "Replication mode to evaluate ('dr' or 'performance')")
-	flag.StringVar(&c.TokenKvMount, "tokenKvMount", "kv", "KV engine mount point where the generated operation token should be stored")
-	flag.Parse()
-
-	for _, arg := range os.Args {
-		if arg == "--help" || arg == "--h" {
-			flag.Usage()
-			os.Exit(0)
-		}
-	}
-
-	flag.VisitAll(func(f *flag.Flag) {
-		if f.Value.String() == "" && f.Name != "opBatchToken" {
-			log.Fatalf("Missing required flag: %s\n", f.Name)
-		}
-	})
-
-	if c.ClientConfig.Mode != "dr" && c.ClientConfig.Mode != "performance" {
-		log.Fatalf("Invalid replication mode: %s\n", c.ClientConfig.Mode)
-	}
-
-	c.ClientConfig.verifyAddrs()
-	c.initialize()
-	c.evaluate()
-	log.Println("Operation completed successfully")
+	runCommand(os.Args[1:])
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsNamespace = "vault_fm"
+
+// Replication roles and states reported as state sets, so that every known
+// value is exported and only the current value is set
+var (
+	knownRoles  = []string{"primary", "secondary", "bootstrapping", "disabled"}
+	knownStates = []string{"running", "stream-wals", "merkle-diff", "merkle-sync", "idle", "connecting", "ready", "transient_failure"}
+)
+
+// A single sample of a metric with its labels, in label order
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// A metric family and its samples, written in the Prometheus text format
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	samples []sample
+}
+
+// Metrics exported while watching the topology. Gauges reflect the most recent
+// poll; counters accumulate for the lifetime of the process.
+type metrics struct {
+	mu         sync.Mutex
+	gauges     []metricFamily
+	lastPoll   time.Time
+	pollErrors int
+	actions    map[[2]string]int
+}
+
+// Metrics shared by every operation within the process
+var operatorMetrics = &metrics{actions: map[[2]string]int{}}
+
+// Record the outcome of an action taken against a cluster
+func recordAction(action string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	operatorMetrics.mu.Lock()
+	defer operatorMetrics.mu.Unlock()
+	operatorMetrics.actions[[2]string{action, result}]++
+}
+
+// Record a failed topology discovery
+func (m *metrics) observeError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pollErrors++
+	m.lastPoll = time.Now()
+}
+
+// Record the gauges derived from a discovered topology and the scenario
+// matched against it
+func (m *metrics) observe(c *ConfigData, s scenario) {
+	gauges := c.metricFamilies(s)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = gauges
+	m.lastPoll = time.Now()
+}
+
+// Add a sample for each known value of a state set, setting only the current
+// value. Values not in the known set are exported as well.
+func stateSet(f *metricFamily, labels []string, known []string, current string) {
+	values := known
+	found := false
+	for _, v := range known {
+		if v == current {
+			found = true
+		}
+	}
+	if !found && current != "" {
+		values = append(append([]string{}, known...), current)
+	}
+	for _, v := range values {
+		value := 0.0
+		if v == current {
+			value = 1
+		}
+		f.samples = append(f.samples, sample{append(append([]string{}, labels...), v), value})
+	}
+}
+
+// Return 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Build the gauge families describing the discovered topology
+func (c *ConfigData) metricFamilies(s scenario) []metricFamily {
+	role := metricFamily{name: "cluster_role", help: "Replication role of the cluster", kind: "gauge", labels: []string{"addr", "cluster_name", "role"}}
+	healthy := metricFamily{name: "cluster_healthy", help: "Whether the cluster is initialized and unsealed", kind: "gauge", labels: []string{"addr"}}
+	sealed := metricFamily{name: "cluster_sealed", help: "Whether the cluster is sealed", kind: "gauge", labels: []string{"addr"}}
+	state := metricFamily{name: "replication_state", help: "Replication state of the cluster", kind: "gauge", labels: []string{"addr", "state"}}
+	lastWal := metricFamily{name: "replication_last_wal", help: "Last WAL index written by the cluster", kind: "gauge", labels: []string{"addr"}}
+	lastRemoteWal := metricFamily{name: "replication_last_remote_wal", help: "Last WAL index received by the secondary cluster from its primary", kind: "gauge", labels: []string{"addr"}}
+	corrupted := metricFamily{name: "replication_corrupted_merkle_tree", help: "Whether the cluster reports a corrupted merkle tree", kind: "gauge", labels: []string{"addr"}}
+	heartbeat := metricFamily{name: "replication_heartbeat_age_seconds", help: "Time since the last heartbeat from a replication peer", kind: "gauge", labels: []string{"addr", "peer"}}
+	skew := metricFamily{name: "replication_clock_skew_ms", help: "Clock skew reported for a replication peer", kind: "gauge", labels: []string{"addr", "peer"}}
+	lag := metricFamily{name: "replication_wal_lag", help: "Number of WALs by which the secondary trails the primary", kind: "gauge", labels: []string{"primary", "secondary"}}
+	tokenValid := metricFamily{name: "op_batch_token_valid", help: "Whether the operation token for the cluster is valid", kind: "gauge", labels: []string{"addr"}}
+	tokenTTL := metricFamily{name: "op_batch_token_ttl_seconds", help: "Remaining TTL of the operation token for the cluster at discovery", kind: "gauge", labels: []string{"addr"}}
+	current := metricFamily{name: "scenario", help: "Scenario matched against the most recently discovered topology", kind: "gauge", labels: []string{"scenario"}}
+
+	for _, snapshot := range c.Topology.Clusters {
+		addr := []string{snapshot.Addr}
+		stateSet(&role, []string{snapshot.Addr, snapshot.Name}, knownRoles, snapshot.ReplicationMode)
+		healthy.samples = append(healthy.samples, sample{addr, boolValue(snapshot.Initialized && !snapshot.Sealed)})
+		sealed.samples = append(sealed.samples, sample{addr, boolValue(snapshot.Sealed)})
+		stateSet(&state, addr, knownStates, snapshot.ReplicationState)
+		lastWal.samples = append(lastWal.samples, sample{addr, snapshot.LastWal})
+		tokenValid.samples = append(tokenValid.samples, sample{addr, boolValue(snapshot.TokenValid)})
+		if snapshot.TokenTTL > 0 {
+			tokenTTL.samples = append(tokenTTL.samples, sample{addr, float64(snapshot.TokenTTL)})
+		}
+
+		var repStatus replicationStatus
+		if len(snapshot.Replication) == 0 || json.Unmarshal(snapshot.Replication, &repStatus) != nil {
+			continue
+		}
+		corrupted.samples = append(corrupted.samples, sample{addr, boolValue(repStatus.CorruptedMerkleTree)})
+		if snapshot.ReplicationMode == "secondary" {
+			lastRemoteWal.samples = append(lastRemoteWal.samples, sample{addr, float64(repStatus.LastRemoteWal)})
+		}
+		for _, peer := range append(repStatus.Primaries, repStatus.Secondaries...) {
+			peerAddr := peer.APIAddress
+			if peerAddr == "" {
+				peerAddr = peer.ClusterAddress
+			}
+			labels := []string{snapshot.Addr, peerAddr}
+			if !peer.LastHeartbeat.IsZero() {
+				heartbeat.samples = append(heartbeat.samples, sample{labels, c.Topology.DiscoveredAt.Sub(peer.LastHeartbeat).Seconds()})
+			}
+			if v, err := strconv.ParseFloat(peer.ClockSkewMs, 64); err == nil {
+				skew.samples = append(skew.samples, sample{labels, v})
+			}
+		}
+	}
+
+	if delta, ok := c.walDelta(); ok {
+		lag.samples = append(lag.samples, sample{[]string{c.PrimaryCluster.Addr, c.SecondaryCluster.Addr}, float64(delta)})
+	}
+	current.samples = append(current.samples, sample{[]string{s.Name}, 1})
+
+	return []metricFamily{role, healthy, sealed, state, lastWal, lastRemoteWal, corrupted, heartbeat, skew, lag, tokenValid, tokenTTL, current}
+}
+
+// Escape a label value for the Prometheus text format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Write a metric family in the Prometheus text format
+func writeMetricFamily(w io.Writer, f metricFamily) {
+	name := metricsNamespace + "_" + f.name
+	fmt.Fprintf(w, "# HELP %s %s\n", name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+	for _, s := range f.samples {
+		var pairs []string
+		for i, label := range f.labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escapeLabel(s.labels[i])))
+		}
+		if len(pairs) > 0 {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(s.value, 'g', -1, 64))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+}
+
+// Write all metrics in the Prometheus text format
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, f := range m.gauges {
+		writeMetricFamily(w, f)
+	}
+
+	lastPoll := metricFamily{name: "last_poll_timestamp_seconds", help: "Unix time of the most recent topology discovery", kind: "gauge"}
+	if !m.lastPoll.IsZero() {
+		lastPoll.samples = append(lastPoll.samples, sample{nil, float64(m.lastPoll.Unix())})
+	}
+	writeMetricFamily(w, lastPoll)
+
+	pollErrors := metricFamily{name: "poll_errors_total", help: "Number of failed topology discoveries", kind: "counter"}
+	pollErrors.samples = append(pollErrors.samples, sample{nil, float64(m.pollErrors)})
+	writeMetricFamily(w, pollErrors)
+
+	actions := metricFamily{name: "actions_total", help: "Number of actions taken against the clusters", kind: "counter", labels: []string{"action", "result"}}
+	var keys [][2]string
+	for k := range m.actions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+	})
+	for _, k := range keys {
+		actions.samples = append(actions.samples, sample{[]string{k[0], k[1]}, float64(m.actions[k])})
+	}
+	writeMetricFamily(w, actions)
+}
+
+// Handle GET /metrics
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// Serve the metrics endpoint in the background
+func (m *metrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("metrics server: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Return the address of a primary that a secondary still sees as live: one it
+// reports as connected, with a heartbeat more recent than the stale heartbeat
+// age. When the operator cannot reach such a primary, the operator is more
+// likely to be partitioned from it than the primary is to have failed.
+func (c *ConfigData) livePrimary(status replicationStatus, now time.Time) (string, bool) {
+	for _, peer := range status.Primaries {
+		if peer.ConnectionStatus != "connected" {
+			continue
+		}
+		stale := c.ClientConfig.Health.StaleHeartbeat > 0 && (peer.LastHeartbeat.IsZero() || now.Sub(peer.LastHeartbeat) > c.ClientConfig.Health.StaleHeartbeat)
+		if stale {
+			continue
+		}
+		addr := peer.APIAddress
+		if addr == "" {
+			addr = peer.ClusterAddress
+		}
+		return addr, true
+	}
+	return "", false
+}
+
+// Refuse to promote a cluster that still sees a live primary, unless explicitly
+// overridden. The secondary's view of the primary is read when the step runs,
+// as a second vantage point to the operator's own.
+func (c *ConfigData) partitionCheckStep(cluster *ClusterData) step {
+	return step{
+		Description: fmt.Sprintf("Check that %s no longer sees a live primary (override with -allowLivePrimary)", cluster.Addr),
+		run: func() error {
+			status, err := c.clusterReplicationStatus(cluster)
+			if err != nil {
+				return fmt.Errorf("partition check: %w", err)
+			}
+			primary, live := c.livePrimary(status, time.Now())
+			if !live {
+				return nil
+			}
+			if !c.ClientConfig.AllowLivePrimary {
+				return fmt.Errorf("refusing to promote %s: it is still connected to primary %s with fresh heartbeats - the operator may be partitioned from the primary; set -allowLivePrimary to promote anyway", cluster.Addr, primary)
+			}
+			log.Printf("WARNING: promoting %s while it is still connected to primary %s (-allowLivePrimary)", cluster.Addr, primary)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// A single operation within an ordered plan of action
+type step struct {
+	Description string `json:"description"`
+	run         func() error
+	// replication paths on which the step requires the update capability
+	requires []requirement
+	// whether the step replaces the operation token, so that later steps do not
+	// depend on the current one
+	replacesToken bool
+}
+
+// Execute the steps of a plan in order, halting on the first failure. The
+// remaining TTL of the operation token and the capabilities required by every
+// step are checked before the first is run; the TTL is not checked for a plan
+// that replaces the operation token. Steps after one that replaces the token
+// act with the new token, so their capabilities are checked once it is in
+// place. When dry-run is enabled, the steps are printed and nothing is
+// executed.
+func (c *ConfigData) execute(steps []step) error {
+	if c.ClientConfig.DryRun {
+		printSteps(steps)
+		return nil
+	}
+
+	if !replacesToken(steps) {
+		err := c.checkTokenTTL()
+		if err != nil {
+			return err
+		}
+	}
+	err := c.preflight(untilTokenReplaced(steps))
+	if err != nil {
+		return err
+	}
+
+	for i, s := range steps {
+		log.Printf("Step %d/%d: %s", i+1, len(steps), s.Description)
+		err := s.run()
+		if err != nil {
+			return err
+		}
+		if s.replacesToken {
+			err = c.preflight(untilTokenReplaced(steps[i+1:]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Return the steps up to and including the first that replaces the operation
+// token, which are the steps that act with the current token
+func untilTokenReplaced(steps []step) []step {
+	for i, s := range steps {
+		if s.replacesToken {
+			return steps[:i+1]
+		}
+	}
+	return steps
+}
+
+// Report whether any of the steps replaces the operation token
+func replacesToken(steps []step) bool {
+	for _, s := range steps {
+		if s.replacesToken {
+			return true
+		}
+	}
+	return false
+}
+
+// Print a scenario and the ordered steps that would be taken in response
+func printPlan(s scenario) {
+	fmt.Printf("Scenario: %s\n", s.Name)
+	fmt.Println(s.Description)
+	if s.Warning != "" {
+		fmt.Println("WARNING:", s.Warning)
+	}
+	if s.Fatal {
+		fmt.Println("The operator would abort without taking action")
+		return
+	}
+	printSteps(s.Steps)
+}
+
+// Print an ordered list of steps
+func printSteps(steps []step) {
+	if len(steps) == 0 {
+		fmt.Println("No action would be taken")
+		return
+	}
+	fmt.Println("Planned steps:")
+	for i, s := range steps {
+		fmt.Printf("  %d. %s\n", i+1, s.Description)
+	}
+}
+
+// Build the request description used for a replication API step
+func (c *ConfigData) replicationStepDescription(method string, path string, addr string) string {
+	return fmt.Sprintf("%s %s%s/%s on %s", method, replicationPath, c.ClientConfig.Mode, path, addr)
+}
+
+// Prompt the operator to confirm before continuing
+func (c *ConfigData) confirmStep(prompt string) step {
+	return step{
+		Description: "Prompt operator for confirmation",
+		run: func() error {
+			if c.ClientConfig.Unattended {
+				return fmt.Errorf("operator confirmation is required but the operator is running unattended")
+			}
+			var dec string
+			fmt.Print(prompt + " [y/n]: ")
+			fmt.Scan(&dec)
+			if dec != "y" {
+				return fmt.Errorf("operation aborted")
+			}
+			return nil
+		},
+	}
+}
+
+// Demote a primary cluster
+func (c *ConfigData) demoteStep(cluster *ClusterData) step {
+	return step{
+		Description: c.replicationStepDescription("POST", "primary/demote", cluster.Addr),
+		run: func() error {
+			err := c.demote(cluster.Client)
+			recordAction("demote", err)
+			if err != nil {
+				return fmt.Errorf("demote: %w", err)
+			}
+			return nil
+		},
+		requires: []requirement{c.replicationRequirement("primary/demote", cluster.Addr, "update")},
+	}
+}
+
+// Promote a secondary cluster
+func (c *ConfigData) promoteStep(cluster *ClusterData) step {
+	return step{
+		Description: c.replicationStepDescription("POST", "secondary/promote", cluster.Addr) + " with primary_cluster_addr=" + cluster.ClusterAddr,
+		run: func() error {
+			err := c.promote(cluster)
+			recordAction("promote", err)
+			if err != nil {
+				return fmt.Errorf("promote: %w", err)
+			}
+			return nil
+		},
+		requires: []requirement{c.replicationRequirement("secondary/promote", cluster.Addr, "update")},
+	}
+}
+
+// Revoke the activation token of a secondary cluster on its primary cluster
+func (c *ConfigData) revokeSecondaryStep(primary *ClusterData, secondary *ClusterData) step {
+	id := secondary.activationTokenId()
+	return step{
+		Description: c.replicationStepDescription("POST", "primary/revoke-secondary", primary.Addr) + " with id=" + id,
+		run: func() error {
+			err := c.revokeSecondary(primary.Addr, c.getHttpClient(), id)
+			if err != nil {
+				return fmt.Errorf("revoke secondary: %w", err)
+			}
+			return nil
+		},
+		requires: []requirement{c.replicationRequirement("primary/revoke-secondary", primary.Addr, "update")},
+	}
+}
+
+// Generate an activation token for a secondary cluster on a primary cluster.
+// The client is resolved when the step runs, as it may be replaced by an
+// earlier step.
+func (c *ConfigData) activationTokenStep(primary *ClusterData, secondary *ClusterData) step {
+	id := secondary.activationTokenId()
+	return step{
+		Description: c.replicationStepDescription("POST", "primary/secondary-token", primary.Addr) + " with id=" + id,
+		run: func() error {
+			err := c.getActivationToken(primary.Client, id)
+			if err != nil {
+				return fmt.Errorf("get activation token: %w", err)
+			}
+			return nil
+		},
+		requires: []requirement{c.replicationRequirement("primary/secondary-token", primary.Addr, "update", "sudo")},
+	}
+}
+
+// Point a secondary cluster to its primary using the activation token
+func (c *ConfigData) updatePrimaryStep(cluster *ClusterData) step {
+	return step{
+		Description: c.replicationStepDescription("POST", "secondary/update-primary", cluster.Addr) + " with the new activation token",
+		run: func() error {
+			return c.updatePrimary(cluster.Client)
+		},
+		requires: []requirement{c.replicationRequirement("secondary/update-primary", cluster.Addr, "update")},
+	}
+}
+
+// Wait for a demoted primary cluster to enter secondary mode
+func (c *ConfigData) waitForSecondaryStep(cluster *ClusterData) step {
+	return step{
+		Description: fmt.Sprintf("Wait for %s to report %s secondary mode", cluster.Addr, c.ClientConfig.Mode),
+		run: func() error {
+			err := c.waitForSecondary(cluster.Client)
+			if err != nil {
+				return fmt.Errorf("wait for secondary: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// Re-initialize the client for the cluster at addr
+func (c *ConfigData) initClientStep(addr string) step {
+	return step{
+		Description: fmt.Sprintf("Re-initialize client for %s", addr),
+		run: func() error {
+			err := c.initClient(addr)
+			if err != nil {
+				return fmt.Errorf("error initializing client for %s: %w", addr, err)
+			}
+			return nil
+		},
+	}
+}
+
+// Generate a new operation batch token
+func (c *ConfigData) generateTokenStep() step {
+	return step{
+		Description: fmt.Sprintf("Obtain a privileged token, create a batch token with the %s policy and store it at %s/%s", handlerPolicyName, c.TokenKvMount, tokenKvPath) + c.clusterTokenDescription(),
+		run: func() error {
+			return generateOpBatchToken(c)
+		},
+		replacesToken: true,
+	}
+}
+
+// Describe the additional tokens generated for performance secondaries
+func (c *ConfigData) clusterTokenDescription() string {
+	if c.ClientConfig.Mode != "performance" || len(c.secondaries()) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", then repeat for each performance secondary, storing its token at %s/%s/<cluster name>", c.TokenKvMount, tokenKvPath)
+}
+
+// Generate a DR operation token on a DR secondary cluster from key shares
+func (c *ConfigData) recoverTokenStep(cluster *ClusterData) step {
+	return step{
+		Description: fmt.Sprintf("Prompt for key shares and generate a DR operation token via %sgenerate-operation-token on %s", replicationPath+"dr/secondary/", cluster.Addr),
+		run: func() error {
+			err := c.recoverOperationToken(cluster)
+			recordAction("token_recovery", err)
+			if err != nil {
+				return fmt.Errorf("recover operation token: %w", err)
+			}
+			return nil
+		},
+		replacesToken: true,
+	}
+}
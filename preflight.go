@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go"
+)
+
+// The capabilities a step requires on a replication path, along with the
+// address of the cluster the step acts on
+type requirement struct {
+	addr         string
+	path         string
+	capabilities []string
+}
+
+// Return the requirement for a replication API step on the cluster at addr
+func (c *ConfigData) replicationRequirement(path string, addr string, capabilities ...string) requirement {
+	return requirement{
+		addr:         addr,
+		path:         strings.TrimPrefix(replicationPath, "/") + c.ClientConfig.Mode + "/" + path,
+		capabilities: capabilities,
+	}
+}
+
+// Return the cluster and token with which a requirement is checked. DR
+// secondaries reject token lookups but share the DR primary's token store, so
+// in DR mode every requirement is checked against the primary, using the token
+// that is passed as the dr_operation_token for DR secondary paths. Without a
+// healthy DR primary, the requirement is checked on the cluster itself with the
+// DR operation token.
+func (c *ConfigData) capabilityTarget(r requirement) (string, string, error) {
+	if c.ClientConfig.Mode != "dr" {
+		token, err := c.tokenFor(r.addr)
+		return r.addr, token, err
+	}
+	if !c.PrimaryCluster.Healthy {
+		token, err := c.drOperationToken()
+		return r.addr, token, err
+	}
+	if strings.Contains(r.path, "/secondary/") {
+		token, err := c.drOperationToken()
+		return c.PrimaryCluster.Addr, token, err
+	}
+	token, err := c.tokenFor(c.PrimaryCluster.Addr)
+	return c.PrimaryCluster.Addr, token, err
+}
+
+// Read the capabilities of the token on each of the given paths with
+// sys/capabilities-self
+func (c *ConfigData) capabilitiesSelf(addr string, token string, paths []string) (map[string][]string, error) {
+	client, err := c.buildClient(addr, token)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Write(context.Background(), "/sys/capabilities-self", map[string]interface{}{
+		"paths": paths,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading token capabilities on %s: %w", addr, err)
+	}
+
+	capabilities := map[string][]string{}
+	for _, path := range paths {
+		list, _ := resp.Data[path].([]interface{})
+		for _, capability := range list {
+			if s, ok := capability.(string); ok {
+				capabilities[path] = append(capabilities[path], s)
+			}
+		}
+	}
+	return capabilities, nil
+}
+
+// Report whether an error reading the token's capabilities is the known
+// limitation of a DR secondary, which serves only its replication endpoints and
+// so cannot report the token's capabilities. A 403, from a token that is invalid
+// or whose policy lacks the sys/capabilities-self grant, is never this
+// limitation.
+func (c *ConfigData) drSecondaryUnchecked(addr string, err error) bool {
+	var responseError *vault.ResponseError
+	if !errors.As(err, &responseError) || responseError.StatusCode == http.StatusForbidden {
+		return false
+	}
+	cluster, _ := c.clusterByAddr(addr)
+	return c.ClientConfig.Mode == "dr" && cluster != nil && cluster.Follower
+}
+
+// Return the capabilities required but not granted, where root grants all
+func missingCapabilities(granted []string, required []string) []string {
+	has := map[string]bool{}
+	for _, capability := range granted {
+		has[capability] = true
+	}
+	if has["root"] {
+		return nil
+	}
+	var missing []string
+	for _, capability := range required {
+		if !has[capability] {
+			missing = append(missing, capability)
+		}
+	}
+	return missing
+}
+
+// Check that the token holds the capabilities required by every step before any
+// step is executed, so that a plan never halts partway through because of a gap
+// in the handler policy. A DR secondary cannot report the token's
+// capabilities, so its requirements are only left unchecked, with a warning,
+// when explicitly allowed.
+func (c *ConfigData) preflight(steps []step) error {
+	type target struct {
+		addr  string
+		token string
+	}
+	requirements := map[target][]requirement{}
+	var targets []target
+	for _, s := range steps {
+		for _, r := range s.requires {
+			addr, token, err := c.capabilityTarget(r)
+			if err != nil {
+				return fmt.Errorf("preflight: %w", err)
+			}
+			t := target{addr, token}
+			if _, ok := requirements[t]; !ok {
+				targets = append(targets, t)
+			}
+			requirements[t] = append(requirements[t], r)
+		}
+	}
+
+	var missing []string
+	checked := 0
+	for _, t := range targets {
+		var paths []string
+		for _, r := range requirements[t] {
+			paths = append(paths, r.path)
+		}
+		capabilities, err := c.capabilitiesSelf(t.addr, t.token, paths)
+		if err != nil && c.drSecondaryUnchecked(t.addr, err) {
+			if !c.ClientConfig.AllowUncheckedDrCapabilities {
+				return fmt.Errorf("preflight: DR secondary %s cannot report the token's capabilities - refusing to start; set -allowUncheckedDrCapabilities to proceed without checking: %w", t.addr, err)
+			}
+			log.Printf("WARN: DR secondary %s cannot report the token's capabilities - %s will not be checked before starting (-allowUncheckedDrCapabilities): %v", t.addr, strings.Join(paths, ", "), err)
+			continue
+		}
+		if vault.IsErrorStatus(err, http.StatusForbidden) {
+			return fmt.Errorf("preflight: token cannot read its own capabilities on %s - it is invalid, or the handler policy predates the sys/capabilities-self grant: %w", t.addr, err)
+		}
+		if err != nil {
+			return fmt.Errorf("preflight: %w", err)
+		}
+		checked++
+		for _, r := range requirements[t] {
+			if lacking := missingCapabilities(capabilities[r.path], r.capabilities); len(lacking) > 0 {
+				missing = append(missing, fmt.Sprintf("%s on %s (checked on %s)", strings.Join(lacking, ","), r.path, t.addr))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("preflight: token is missing capabilities %s - refusing to start", strings.Join(missing, "; "))
+	}
+	if checked > 0 {
+		log.Println("Preflight capability check passed")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMissingCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required []string
+		want     []string
+	}{
+		{
+			name:     "all granted",
+			granted:  []string{"update", "sudo"},
+			required: []string{"update", "sudo"},
+			want:     nil,
+		},
+		{
+			name:     "sudo missing",
+			granted:  []string{"update"},
+			required: []string{"update", "sudo"},
+			want:     []string{"sudo"},
+		},
+		{
+			name:     "nothing granted",
+			granted:  nil,
+			required: []string{"update"},
+			want:     []string{"update"},
+		},
+		{
+			name:     "deny",
+			granted:  []string{"deny"},
+			required: []string{"update"},
+			want:     []string{"update"},
+		},
+		{
+			name:     "root grants all",
+			granted:  []string{"root"},
+			required: []string{"update", "sudo"},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := missingCapabilities(tt.granted, tt.required)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreflightTokenRecoveryWithoutBatchToken(t *testing.T) {
+	c := ConfigData{
+		ClientConfig:     ClientConfig{Mode: "dr", ConfiguredAddrs: "https://a:8200,https://b:8200"},
+		SecondaryCluster: ClusterData{Addr: "https://b:8200", Healthy: true, Follower: true},
+	}
+	s := c.selectScenario()
+	if s.Name != "token-recovery" {
+		t.Fatalf("selectScenario() = %s, want token-recovery", s.Name)
+	}
+
+	before := untilTokenReplaced(s.Steps)
+	if len(before) == len(s.Steps) || !before[len(before)-1].replacesToken {
+		t.Fatalf("steps before recovery end with %q, want the token recovery step", before[len(before)-1].Description)
+	}
+	if err := c.preflight(before); err != nil {
+		t.Errorf("preflight of the steps before recovery: %v", err)
+	}
+	if err := c.preflight(s.Steps[len(before):]); err == nil {
+		t.Error("preflight of the steps after recovery succeeded without a DR operation token")
+	}
+}
+
+func TestPreflightCapabilityErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		status    int
+		body      string
+		allow     bool
+		wantError bool
+	}{
+		{
+			name:   "capabilities granted",
+			mode:   "performance",
+			status: http.StatusOK,
+			body:   `{"data": {"sys/replication/performance/secondary/promote": ["update"]}}`,
+		},
+		{
+			name:      "capability missing",
+			mode:      "performance",
+			status:    http.StatusOK,
+			body:      `{"data": {"sys/replication/performance/secondary/promote": ["read"]}}`,
+			wantError: true,
+		},
+		{
+			name:      "capabilities-self forbidden",
+			mode:      "performance",
+			status:    http.StatusForbidden,
+			body:      `{"errors": ["permission denied"]}`,
+			wantError: true,
+		},
+		{
+			name:      "DR secondary cannot report capabilities",
+			mode:      "dr",
+			status:    http.StatusBadRequest,
+			body:      `{"errors": ["path disabled in replication DR secondary mode"]}`,
+			wantError: true,
+		},
+		{
+			name:   "DR secondary cannot report capabilities, unchecked allowed",
+			mode:   "dr",
+			status: http.StatusBadRequest,
+			body:   `{"errors": ["path disabled in replication DR secondary mode"]}`,
+			allow:  true,
+		},
+		{
+			name:      "DR secondary forbidden, unchecked allowed",
+			mode:      "dr",
+			status:    http.StatusForbidden,
+			body:      `{"errors": ["permission denied"]}`,
+			allow:     true,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := ConfigData{
+				ClientConfig: ClientConfig{
+					Mode:                         tt.mode,
+					OpBatchToken:                 "token",
+					AllowUncheckedDrCapabilities: tt.allow,
+				},
+				SecondaryCluster: ClusterData{Addr: srv.URL, Healthy: true, Follower: true},
+			}
+			err := c.preflight([]step{c.promoteStep(&c.SecondaryCluster)})
+			if (err != nil) != tt.wantError {
+				t.Errorf("preflight() error = %v, want error %t", err, tt.wantError)
+			}
+		})
+	}
+}
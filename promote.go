@@ -11,13 +11,7 @@ import (
 )
 
 // Wait for replication mode to be set to "secondary" on newly-demoted cluster
-func (c *ConfigData) waitForSecondary(override bool) error {
-	var client *vault.Client
-	if override {
-		client = c.SecondaryCluster.Client
-	} else {
-		client = c.PrimaryCluster.Client
-	}
+func (c *ConfigData) waitForSecondary(client *vault.Client) error {
 	switch c.ClientConfig.Mode {
 	case "dr":
 		var tempStatus SecondaryDrConfig
@@ -72,23 +66,28 @@ func (c *ConfigData) waitForSecondary(override bool) error {
 }
 
 // Promote a secondary cluster
-func (c *ConfigData) promote() error {
+func (c *ConfigData) promote(cluster *ClusterData) error {
 	promotePayload := map[string]interface{}{
-		"primary_cluster_addr": c.SecondaryCluster.ClusterAddr,
+		"primary_cluster_addr": cluster.ClusterAddr,
 		"force":                false,
 	}
 
 	if c.ClientConfig.Mode == "dr" {
-		promotePayload["dr_operation_token"] = c.ClientConfig.OpBatchToken
+		drOperationToken, err := c.drOperationToken()
+		if err != nil {
+			return fmt.Errorf("secondary promotion operation failed: %w", err)
+		}
+		promotePayload["dr_operation_token"] = drOperationToken
 	}
 
 	log.Println("Promoting secondary cluster...")
-	_, err := c.SecondaryCluster.Client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/secondary/promote", promotePayload)
+	_, err := cluster.Client.Write(context.Background(), replicationPath+c.ClientConfig.Mode+"/secondary/promote", promotePayload)
 	if err != nil {
 		return fmt.Errorf("secondary promotion operation failed: %w", err)
 	}
+	name := cluster.Name
 	for {
-		err = c.initClient(c.SecondaryCluster.Addr)
+		err = c.initClient(cluster.Addr)
 		if err != nil {
 			log.Println("Waiting for cluster to be ready...")
 			time.Sleep(timeout)
@@ -97,12 +96,12 @@ func (c *ConfigData) promote() error {
 		}
 	}
 
-	resp, err := c.SecondaryCluster.Client.System.ReadHealthStatus(context.Background())
+	resp, err := cluster.Client.System.ReadHealthStatus(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get health status of new primary cluster: %w", err)
 	}
-	if resp.Data["cluster_name"] != c.SecondaryCluster.Name {
-		return fmt.Errorf("expected cluster name %s does not match discovered cluster name %s", c.SecondaryCluster.Name, resp.Data["cluster_name"])
+	if resp.Data["cluster_name"] != name {
+		return fmt.Errorf("expected cluster name %s does not match discovered cluster name %s", name, resp.Data["cluster_name"])
 	} else {
 		log.Println("Successfully re-authenticated with new primary cluster and confirmed cluster name")
 	}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go"
+	"golang.org/x/term"
+)
+
+const operationTokenPath = replicationPath + "dr/secondary/generate-operation-token/"
+
+// The state of a DR operation token generation attempt on a DR secondary
+type operationTokenAttempt struct {
+	Nonce            string `json:"nonce"`
+	Started          bool   `json:"started"`
+	Progress         int    `json:"progress"`
+	Required         int    `json:"required"`
+	Complete         bool   `json:"complete"`
+	EncodedToken     string `json:"encoded_token"`
+	EncodedRootToken string `json:"encoded_root_token"`
+	PgpFingerprint   string `json:"pgp_fingerprint"`
+	Otp              string `json:"otp"`
+}
+
+// Decode a response from the generate-operation-token endpoints
+func decodeAttempt(data map[string]interface{}) (operationTokenAttempt, error) {
+	var attempt operationTokenAttempt
+	b, _ := json.Marshal(data)
+	err := json.Unmarshal(b, &attempt)
+	if err != nil {
+		return attempt, fmt.Errorf("failed to unmarshal operation token generation status: %w", err)
+	}
+	if attempt.EncodedToken == "" {
+		attempt.EncodedToken = attempt.EncodedRootToken
+	}
+	return attempt, nil
+}
+
+// Read the state of the current DR operation token generation attempt
+func readOperationTokenAttempt(client *vault.Client) (operationTokenAttempt, error) {
+	resp, err := client.Read(context.Background(), operationTokenPath+"attempt")
+	if err != nil {
+		return operationTokenAttempt{}, fmt.Errorf("error reading operation token generation attempt: %w", err)
+	}
+	return decodeAttempt(resp.Data)
+}
+
+// Start a DR operation token generation attempt. The resulting token is
+// encrypted with the given PGP key, or encoded with a server-generated OTP when
+// no key is given.
+func startOperationTokenAttempt(client *vault.Client, pgpKey string) (operationTokenAttempt, error) {
+	body := map[string]interface{}{}
+	if pgpKey != "" {
+		body["pgp_key"] = pgpKey
+	}
+	resp, err := client.Write(context.Background(), operationTokenPath+"attempt", body)
+	if err != nil {
+		return operationTokenAttempt{}, fmt.Errorf("error starting operation token generation attempt: %w", err)
+	}
+	attempt, err := decodeAttempt(resp.Data)
+	if err != nil {
+		return attempt, err
+	}
+	if pgpKey == "" && attempt.Otp == "" {
+		return attempt, fmt.Errorf("cluster did not return an OTP for the operation token generation attempt")
+	}
+	return attempt, nil
+}
+
+// Cancel the current DR operation token generation attempt
+func cancelOperationTokenAttempt(client *vault.Client) error {
+	_, err := client.Delete(context.Background(), operationTokenPath+"attempt")
+	if err != nil {
+		return fmt.Errorf("error cancelling operation token generation attempt: %w", err)
+	}
+	return nil
+}
+
+// Submit a single unseal or recovery key share to the attempt with the given nonce
+func submitOperationTokenShare(client *vault.Client, nonce string, key string) (operationTokenAttempt, error) {
+	resp, err := client.Write(context.Background(), operationTokenPath+"update", map[string]interface{}{
+		"nonce": nonce,
+		"key":   key,
+	})
+	if err != nil {
+		return operationTokenAttempt{}, fmt.Errorf("error submitting key share: %w", err)
+	}
+	return decodeAttempt(resp.Data)
+}
+
+// Decode an OTP-encoded operation token: the base64-decoded token is XORed with
+// the OTP
+func decodeOperationToken(encoded string, otp string) (string, error) {
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", fmt.Errorf("error decoding operation token: %w", err)
+	}
+	if len(tokenBytes) != len(otp) {
+		return "", fmt.Errorf("encoded operation token and OTP lengths differ")
+	}
+	for i := range tokenBytes {
+		tokenBytes[i] ^= otp[i]
+	}
+	return string(tokenBytes), nil
+}
+
+// Read the PGP public key used to encrypt the operation token, if configured
+func (c *ConfigData) recoveryPgpKey() (string, error) {
+	if c.ClientConfig.RecoveryPgpKeyFile == "" {
+		return "", nil
+	}
+	key, err := os.ReadFile(c.ClientConfig.RecoveryPgpKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading PGP key file: %w", err)
+	}
+	return strings.TrimSpace(string(key)), nil
+}
+
+// Obtain the operation token from a completed attempt, decoding it with the OTP
+// or, when PGP-encrypted, asking the operator for the decrypted token
+func (c *ConfigData) completedOperationToken(attempt operationTokenAttempt, otp string) (string, error) {
+	if otp != "" {
+		return decodeOperationToken(attempt.EncodedToken, otp)
+	}
+
+	fmt.Printf("Encrypted operation token (PGP key fingerprint %s):\n%s\n", attempt.PgpFingerprint, attempt.EncodedToken)
+	fmt.Println("Decrypt the token with: echo <token> | base64 -d | gpg -dq")
+	fmt.Print("Decrypted operation token: ")
+	token, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("error reading operation token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Generate a DR operation token on the DR secondary cluster from unseal or
+// recovery key shares entered by the operator, for use when the primary
+// cluster is unavailable to validate or issue an operation batch token. The
+// recovered token replaces the operation batch token for the remaining steps.
+func (c *ConfigData) recoverOperationToken(cluster *ClusterData) error {
+	if c.ClientConfig.Unattended {
+		return fmt.Errorf("operation token recovery requires an interactive operator")
+	}
+	if c.ClientConfig.Mode != "dr" {
+		return fmt.Errorf("operation token recovery is only supported for DR replication")
+	}
+
+	pgpKey, err := c.recoveryPgpKey()
+	if err != nil {
+		return err
+	}
+
+	attempt, err := readOperationTokenAttempt(cluster.Client)
+	if err != nil {
+		return err
+	}
+	if attempt.Started {
+		var dec string
+		fmt.Print("An operation token generation attempt is already in progress - cancel it and start a new one? [y/n]: ")
+		fmt.Scan(&dec)
+		if dec != "y" {
+			return fmt.Errorf("operation aborted")
+		}
+		err = cancelOperationTokenAttempt(cluster.Client)
+		if err != nil {
+			return err
+		}
+	}
+
+	attempt, err = startOperationTokenAttempt(cluster.Client, pgpKey)
+	if err != nil {
+		return err
+	}
+	nonce, otp := attempt.Nonce, attempt.Otp
+	log.Printf("Started operation token generation on %s (nonce %s): %d key shares required", cluster.Addr, nonce, attempt.Required)
+
+	for !attempt.Complete {
+		fmt.Printf("Key share (%d/%d): ", attempt.Progress+1, attempt.Required)
+		key, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("error reading key share: %w", err)
+		}
+		attempt, err = submitOperationTokenShare(cluster.Client, nonce, strings.TrimSpace(string(key)))
+		if err != nil {
+			return err
+		}
+		if !attempt.Complete {
+			log.Printf("Key share accepted (%d/%d)", attempt.Progress, attempt.Required)
+		}
+	}
+
+	token, err := c.completedOperationToken(attempt, otp)
+	if err != nil {
+		return err
+	}
+	c.ClientConfig.OpBatchToken = token
+	log.Println("DR operation token recovered")
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodeOperationToken(t *testing.T) {
+	token := "hvb.AAAAAQJ2operationtoken"
+	otp := "0123456789abcdefghijklmnop"
+	xored := make([]byte, len(token))
+	for i := range xored {
+		xored[i] = token[i] ^ otp[i]
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		otp     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "unpadded",
+			encoded: base64.RawStdEncoding.EncodeToString(xored),
+			otp:     otp,
+			want:    token,
+		},
+		{
+			name:    "padded",
+			encoded: base64.StdEncoding.EncodeToString(xored),
+			otp:     otp,
+			want:    token,
+		},
+		{
+			name:    "OTP length differs",
+			encoded: base64.RawStdEncoding.EncodeToString(xored),
+			otp:     otp[1:],
+			wantErr: true,
+		},
+		{
+			name:    "not base64",
+			encoded: "not base64!",
+			otp:     otp,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeOperationToken(tt.encoded, tt.otp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeOperationToken() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeOperationToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
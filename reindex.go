@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Merkle sync states in which a secondary is still reconciling with its
+// primary and must not be promoted
+var merkleSyncStates = map[string]bool{
+	"merkle-sync": true,
+	"merkle-diff": true,
+}
+
+// Return the replication status of a cluster, read from the cluster when its
+// client is initialized and from the discovered topology otherwise
+func (c *ConfigData) clusterReplicationStatus(cluster *ClusterData) (replicationStatus, error) {
+	var status replicationStatus
+	if cluster.Client != nil {
+		b, err := readReplicationStatus(cluster.Client, c.ClientConfig.Mode)
+		if err == nil {
+			err = json.Unmarshal(b, &status)
+		}
+		return status, err
+	}
+	for _, snapshot := range c.Topology.Clusters {
+		if snapshot.Addr == cluster.Addr {
+			err := json.Unmarshal(snapshot.Replication, &status)
+			return status, err
+		}
+	}
+	return status, fmt.Errorf("no replication status discovered for %s", cluster.Addr)
+}
+
+// Refuse to promote a cluster whose merkle tree is corrupted, or that is still
+// in merkle-sync or merkle-diff, unless explicitly overridden
+func (c *ConfigData) merkleCheckStep(cluster *ClusterData) step {
+	return step{
+		Description: fmt.Sprintf("Check that %s does not have a corrupted merkle tree and is not in merkle-sync or merkle-diff (override with -allowCorruptedMerkle)", cluster.Addr),
+		run: func() error {
+			status, err := c.clusterReplicationStatus(cluster)
+			if err != nil {
+				return fmt.Errorf("merkle check: %w", err)
+			}
+
+			var problem string
+			switch {
+			case status.CorruptedMerkleTree:
+				problem = fmt.Sprintf("merkle tree is corrupted (last corruption check epoch %s)", status.LastCorruptionCheckEpoch)
+			case merkleSyncStates[status.State]:
+				problem = fmt.Sprintf("replication state is %s", status.State)
+			default:
+				return nil
+			}
+			if !c.ClientConfig.AllowCorruptedMerkle {
+				return fmt.Errorf("refusing to promote %s: %s - run the reindex command, or set -allowCorruptedMerkle to promote anyway", cluster.Addr, problem)
+			}
+			log.Printf("WARNING: promoting %s despite its %s (-allowCorruptedMerkle)", cluster.Addr, problem)
+			return nil
+		},
+	}
+}
+
+// Return the path of a reindex or merkle-check operation on a cluster. DR
+// secondaries expose their own endpoints, authorized by the DR operation token.
+func (c *ConfigData) reindexPath(cluster *ClusterData, operation string) string {
+	if c.ClientConfig.Mode == "dr" && cluster.Follower {
+		return replicationPath + "dr/secondary/" + operation
+	}
+	return replicationPath + operation
+}
+
+// Trigger a reindex or merkle check of the replicated data on a cluster. The
+// cluster's own endpoints are root-protected and require sudo; the DR
+// secondary endpoints are authorized by the DR operation token instead.
+func (c *ConfigData) reindexStep(cluster *ClusterData, operation string) step {
+	path := c.reindexPath(cluster, operation)
+	capabilities := []string{"update", "sudo"}
+	if c.ClientConfig.Mode == "dr" && cluster.Follower {
+		capabilities = []string{"update"}
+	}
+	return step{
+		Description: fmt.Sprintf("POST %s on %s", path, cluster.Addr),
+		run: func() error {
+			body := map[string]interface{}{}
+			if c.ClientConfig.Mode == "dr" && cluster.Follower {
+				drOperationToken, err := c.drOperationToken()
+				if err != nil {
+					return fmt.Errorf("%s: %w", operation, err)
+				}
+				body["dr_operation_token"] = drOperationToken
+			}
+			resp, err := cluster.Client.Write(context.Background(), path, body)
+			recordAction(operation, err)
+			if err != nil {
+				return fmt.Errorf("%s: %w", operation, err)
+			}
+			if operation == "merkle-check" && resp != nil {
+				result, _ := json.MarshalIndent(resp.Data, "", "  ")
+				fmt.Println(string(result))
+			}
+			return nil
+		},
+		requires: []requirement{{addr: cluster.Addr, path: strings.TrimPrefix(path, "/"), capabilities: capabilities}},
+	}
+}
+
+// The number of consecutive failures to read the replication status tolerated
+// while tracking a reindex
+const maxReindexStatusErrors = 10
+
+// Track the progress of a reindex on a cluster until it completes, giving up
+// once the wait is exceeded or the cluster repeatedly fails to report its status
+func (c *ConfigData) reindexProgressStep(cluster *ClusterData, wait time.Duration) step {
+	return step{
+		Description: fmt.Sprintf("Wait up to %s for the reindex of %s to complete", wait, cluster.Addr),
+		run: func() error {
+			deadline := time.Now().Add(wait)
+			failures := 0
+			for {
+				if time.Now().After(deadline) {
+					return fmt.Errorf("reindex of %s did not complete within %s", cluster.Addr, wait)
+				}
+				time.Sleep(timeout)
+				status, err := c.clusterReplicationStatus(cluster)
+				if err != nil {
+					failures++
+					if failures >= maxReindexStatusErrors {
+						return fmt.Errorf("reindex progress of %s: %w", cluster.Addr, err)
+					}
+					log.Println("Waiting for cluster to report reindex progress...")
+					continue
+				}
+				failures = 0
+				if !status.ReindexInProgress {
+					log.Printf("Reindex of %s complete", cluster.Addr)
+					if status.CorruptedMerkleTree {
+						return fmt.Errorf("merkle tree of %s is still reported as corrupted", cluster.Addr)
+					}
+					return nil
+				}
+				if status.ReindexBuildingTotal > 0 {
+					log.Printf("Reindex %s: %d/%d", status.ReindexStage, status.ReindexBuildingProgress, status.ReindexBuildingTotal)
+				} else {
+					log.Printf("Reindex %s", status.ReindexStage)
+				}
+			}
+		},
+	}
+}
+
+// Reindex the replicated data on a cluster, or check its merkle tree for
+// corruption
+func runReindex(args []string) {
+	c := ConfigData{}
+	fs := c.newFlagSet("reindex")
+	target := fs.String("target", "", "Address of the cluster to reindex (defaults to the secondary cluster)")
+	merkleCheck := fs.Bool("merkleCheck", false, "Check the merkle tree for corruption instead of reindexing")
+	wait := fs.Duration("reindexTimeout", time.Hour, "How long to wait for the reindex to complete")
+	c.setup(fs, args)
+
+	cluster := &c.SecondaryCluster
+	if *target != "" {
+		cluster, _ = c.clusterByAddr(*target)
+		if cluster == nil {
+			log.Fatalf("Unknown target cluster: %s\n", *target)
+		}
+	}
+	if cluster.Client == nil {
+		log.Fatalf("Client for %s is not initialized - cannot reindex\n", cluster.Addr)
+	}
+
+	steps := []step{c.reindexStep(cluster, "reindex"), c.reindexProgressStep(cluster, *wait)}
+	if *merkleCheck {
+		steps = []step{c.reindexStep(cluster, "merkle-check")}
+	}
+	err := c.execute(steps)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	c.logCompletion()
+}
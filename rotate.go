@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+)
+
+// A stored operations token being replaced on a single cluster
+type rotation struct {
+	cluster   *ClusterData
+	path      string
+	kvVersion string
+	client    *vault.Client
+	token     string
+	previous  map[string]interface{}
+}
+
+// Return the path at which the previous token is kept during rotation
+func previousTokenPath(path string) string {
+	return path + "-previous"
+}
+
+// Replace the operations token stored for a single cluster. The current token
+// is copied to the previous token path first, so that it remains available
+// until the new token has been verified.
+func (c *ConfigData) rotateClusterToken(cluster *ClusterData, path string, ttl string) (rotation, error) {
+	r := rotation{cluster: cluster, path: path}
+
+	token, err := c.rotationToken(cluster)
+	if err != nil {
+		return r, err
+	}
+	r.client, err = c.buildClient(cluster.Addr, token)
+	if err != nil {
+		return r, fmt.Errorf("build client: %w", err)
+	}
+	lookup, err := r.client.Auth.TokenLookUpSelf(context.Background())
+	if err != nil {
+		return r, fmt.Errorf("error querying for token: %w", err)
+	}
+	creatorName, _ := lookup.Data["display_name"].(string)
+
+	r.kvVersion, err = verifyKvEngine(r.client, c.TokenKvMount)
+	if err != nil {
+		return r, fmt.Errorf("verifyKvEngine: %w", err)
+	}
+
+	r.previous, err = readTokenRecord(r.kvVersion, r.client, c.TokenKvMount, path)
+	if err != nil {
+		log.Printf("WARN: no current token to keep for %s: %v", cluster.Addr, err)
+		r.previous = nil
+	} else {
+		err = storeToken(r.kvVersion, r.client, r.previous, c.TokenKvMount, previousTokenPath(path))
+		if err != nil {
+			return r, fmt.Errorf("storeToken: %w", err)
+		}
+	}
+
+	r.token, err = createToken(r.client, creatorName, ttl)
+	if err != nil {
+		return r, fmt.Errorf("createToken: %w", err)
+	}
+	record := tokenRecord(r.token, creatorName)
+	record["rotated_at"] = record["created_at"]
+	record["ttl"] = ttl
+	if r.previous != nil {
+		record["previous_created_at"] = r.previous["created_at"]
+	}
+	err = storeToken(r.kvVersion, r.client, record, c.TokenKvMount, path)
+	if err != nil {
+		return r, fmt.Errorf("storeToken: %w", err)
+	}
+	return r, nil
+}
+
+// Return the token used to rotate the operations token on a cluster: a token
+// obtained with the configured auth method, or the cluster's privileged token.
+// Rotation runs without an operator, so the token is never prompted for.
+func (c *ConfigData) rotationToken(cluster *ClusterData) (string, error) {
+	if c.ClientConfig.authenticator != nil {
+		return c.tokenFor(cluster.Addr)
+	}
+	token, err := c.configuredPrivilegedToken(cluster)
+	if err != nil || token != "" {
+		return token, err
+	}
+	if !c.sharesPrivilegedToken(cluster) {
+		return "", fmt.Errorf("rotating the token on %s requires -authMethod or a -privilegedTokenFiles entry for the cluster", cluster.Addr)
+	}
+	return "", fmt.Errorf("rotating the token requires -authMethod, -privilegedTokenFile or $VAULT_TOKEN")
+}
+
+// Verify the rotated tokens by discovering the topology with them in place of
+// the current tokens. Every rotated cluster must accept its new token; on each
+// healthy DR secondary, the primary's new token must be usable as a
+// dr_operation_token. Clusters skipped by the rotation are not verified.
+func (c *ConfigData) verifyRotation(rotations []rotation) error {
+	v := c.fresh()
+	v.ClientConfig.authenticator = nil
+	v.ClientConfig.OpBatchTokenFromKV = false
+	v.ClientConfig.OpBatchTokens = tokenMap{}
+	verify := map[string]bool{}
+	for _, r := range rotations {
+		verify[r.cluster.Addr] = true
+		if r.cluster == &c.PrimaryCluster {
+			v.ClientConfig.OpBatchToken = r.token
+		} else {
+			v.ClientConfig.OpBatchTokens[r.cluster.Addr] = r.token
+		}
+	}
+
+	if c.ClientConfig.Mode == "dr" {
+		for _, secondary := range c.secondaries() {
+			if secondary.Healthy {
+				verify[secondary.Addr] = true
+			}
+		}
+	}
+
+	t, err := v.getTopology(c.ClientConfig.VerifiedAddrs)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range t.Clusters {
+		if verify[snapshot.Addr] && !snapshot.TokenValid {
+			return fmt.Errorf("new token could not be verified on %s (%s)", snapshot.Addr, snapshot.TokenCheck)
+		}
+	}
+	return nil
+}
+
+// Mint a new operations batch token with the given TTL on the primary cluster
+// and, in performance mode, on each performance secondary, and store each in
+// the KV engine in place of the current one. The previous tokens are kept until
+// the new ones are verified on every cluster, and restored if verification
+// fails. Tokens are created with the identity from the configured auth method
+// or each cluster's privileged token, so no operator is required.
+func (c *ConfigData) rotateOpBatchToken(ttl string) error {
+	if _, err := time.ParseDuration(ttl); err != nil {
+		return fmt.Errorf("invalid token TTL %q: %w", ttl, err)
+	}
+	if !c.PrimaryCluster.Healthy {
+		return fmt.Errorf("primary cluster is not healthy - cannot rotate operation batch token")
+	}
+
+	targets := map[*ClusterData]string{&c.PrimaryCluster: tokenKvPath}
+	order := []*ClusterData{&c.PrimaryCluster}
+	if c.ClientConfig.Mode == "performance" {
+		for _, secondary := range c.secondaries() {
+			if !secondary.Healthy {
+				log.Printf("WARN: skipping token rotation for unhealthy cluster %s", secondary.Addr)
+				continue
+			}
+			targets[secondary] = tokenKvPath + "/" + secondary.Name
+			order = append(order, secondary)
+		}
+	}
+
+	var rotations []rotation
+	var err error
+	for _, cluster := range order {
+		var r rotation
+		r, err = c.rotateClusterToken(cluster, targets[cluster], ttl)
+		if r.token != "" {
+			rotations = append(rotations, r)
+		}
+		if err != nil {
+			err = fmt.Errorf("rotating token for %s: %w", cluster.Addr, err)
+			break
+		}
+	}
+	if err == nil {
+		err = c.verifyRotation(rotations)
+	}
+
+	if err != nil {
+		for _, r := range rotations {
+			if r.previous == nil {
+				continue
+			}
+			restoreErr := storeToken(r.kvVersion, r.client, r.previous, c.TokenKvMount, r.path)
+			if restoreErr != nil {
+				log.Printf("ERROR: could not restore the previous token for %s - it remains at %s/%s: %v", r.cluster.Addr, c.TokenKvMount, previousTokenPath(r.path), restoreErr)
+			}
+		}
+		return fmt.Errorf("token rotation failed, previous tokens restored: %w", err)
+	}
+
+	for _, r := range rotations {
+		if r.previous == nil {
+			continue
+		}
+		err = deleteToken(r.kvVersion, r.client, c.TokenKvMount, previousTokenPath(r.path))
+		if err != nil {
+			log.Printf("WARN: %v", err)
+		}
+	}
+	log.Printf("Operation batch token rotated on %d cluster(s) with a TTL of %s", len(rotations), ttl)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotationToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := func(name string, token string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	shared := tokenFile("shared", "shared-file-token")
+	byName := tokenFile("perf-b", "perf-b-token")
+	byAddr := tokenFile("perf-c", "perf-c-token")
+	t.Setenv("VAULT_TOKEN", "env-token")
+
+	tests := []struct {
+		name       string
+		mode       string
+		sharedFile string
+		cluster    func(c *ConfigData) *ClusterData
+		want       string
+		wantError  bool
+	}{
+		{
+			name:    "performance primary uses $VAULT_TOKEN",
+			mode:    "performance",
+			cluster: func(c *ConfigData) *ClusterData { return &c.PrimaryCluster },
+			want:    "env-token",
+		},
+		{
+			name:       "performance primary prefers the shared file",
+			mode:       "performance",
+			sharedFile: shared,
+			cluster:    func(c *ConfigData) *ClusterData { return &c.PrimaryCluster },
+			want:       "shared-file-token",
+		},
+		{
+			name:       "performance secondary file by cluster name",
+			mode:       "performance",
+			sharedFile: shared,
+			cluster:    func(c *ConfigData) *ClusterData { return &c.SecondaryCluster },
+			want:       "perf-b-token",
+		},
+		{
+			name:    "performance secondary file by address",
+			mode:    "performance",
+			cluster: func(c *ConfigData) *ClusterData { return &c.AdditionalSecondaries[0] },
+			want:    "perf-c-token",
+		},
+		{
+			name:       "performance secondary without a file",
+			mode:       "performance",
+			sharedFile: shared,
+			cluster:    func(c *ConfigData) *ClusterData { return &c.AdditionalSecondaries[1] },
+			wantError:  true,
+		},
+		{
+			name:    "DR secondary shares $VAULT_TOKEN",
+			mode:    "dr",
+			cluster: func(c *ConfigData) *ClusterData { return &c.AdditionalSecondaries[1] },
+			want:    "env-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ConfigData{
+				ClientConfig: ClientConfig{
+					Mode: tt.mode,
+					Generate: GenerateConfig{
+						PrivilegedTokenFile:  tt.sharedFile,
+						PrivilegedTokenFiles: fileMap{"perf-b": byName, "https://c:8200": byAddr},
+					},
+				},
+				PrimaryCluster:   ClusterData{Addr: "https://a:8200", Name: "perf-a"},
+				SecondaryCluster: ClusterData{Addr: "https://b:8200", Name: "perf-b"},
+				AdditionalSecondaries: []ClusterData{
+					{Addr: "https://c:8200", Name: "perf-c"},
+					{Addr: "https://d:8200", Name: "perf-d"},
+				},
+			}
+			got, err := c.rotationToken(tt.cluster(&c))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("rotationToken() error = %v, want error %t", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("rotationToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Configuration and state of the HTTP control API
+type server struct {
+	config          ConfigData
+	listenAddr      string
+	apiToken        string
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+	// serializes operations so that concurrent triggers cannot act at once
+	mu sync.Mutex
+}
+
+// The result of an operation triggered through the control API
+type operationResult struct {
+	Scenario *scenario `json:"scenario,omitempty"`
+	Steps    []step    `json:"steps,omitempty"`
+	DryRun   bool      `json:"dryRun"`
+	Executed bool      `json:"executed"`
+	// the vote of each witness, when the operation was gated on a witness quorum
+	WitnessVotes []witnessVote `json:"witnessVotes,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Write a JSON response with the given status code
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Require a valid shared secret on requests, unless the API is protected by
+// mutual TLS alone
+func (s *server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+				log.Printf("Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				writeJson(w, http.StatusUnauthorized, operationResult{Error: "unauthorized"})
+				return
+			}
+		}
+		log.Printf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		next(w, r)
+	}
+}
+
+// Run fn while holding the operation lock, rejecting the request if another
+// operation is already in progress
+func (s *server) serialize(w http.ResponseWriter, fn func()) {
+	if !s.mu.TryLock() {
+		writeJson(w, http.StatusConflict, operationResult{Error: "another operation is in progress"})
+		return
+	}
+	defer s.mu.Unlock()
+	fn()
+}
+
+// Discover the topology for a single request
+func (s *server) discover(r *http.Request) (ConfigData, error) {
+	c := s.config.fresh()
+	c.ClientConfig.DryRun = c.ClientConfig.DryRun || r.URL.Query().Get("dryRun") == "true"
+	err := c.refresh()
+	return c, err
+}
+
+// Handle GET /v1/topology
+func (s *server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJson(w, http.StatusMethodNotAllowed, operationResult{Error: "method not allowed"})
+		return
+	}
+	c, err := s.discover(r)
+	if err != nil {
+		writeJson(w, http.StatusBadGateway, operationResult{Error: err.Error()})
+		return
+	}
+	report := c.getStatusReport()
+	writeJson(w, http.StatusOK, report)
+}
+
+// Handle POST /v1/evaluate by acting on the discovered scenario. Scenarios that
+// would prompt the operator are returned as a plan without being executed.
+func (s *server) handleEvaluate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusMethodNotAllowed, operationResult{Error: "method not allowed"})
+		return
+	}
+	s.serialize(w, func() {
+		c, err := s.discover(r)
+		if err != nil {
+			writeJson(w, http.StatusBadGateway, operationResult{Error: err.Error()})
+			return
+		}
+		c.confirmReplication()
+
+		sc := c.selectScenario()
+		result := operationResult{Scenario: &sc, DryRun: c.ClientConfig.DryRun}
+		switch {
+		case sc.Fatal:
+			result.Error = sc.Description
+			writeJson(w, http.StatusConflict, result)
+			return
+		case !sc.Automatic || c.ClientConfig.DryRun || len(sc.Steps) == 0:
+			writeJson(w, http.StatusOK, result)
+			return
+		}
+
+		log.Println(sc.Description)
+		err = c.executeScenario(sc)
+		result.Executed = true
+		result.WitnessVotes = c.WitnessVotes
+		if err != nil {
+			result.Error = err.Error()
+			writeJson(w, http.StatusInternalServerError, result)
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	})
+}
+
+// Handle POST /v1/failover by demoting the primary and promoting the secondary.
+// The authenticated request stands in for the operator's confirmation.
+func (s *server) handleFailover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJson(w, http.StatusMethodNotAllowed, operationResult{Error: "method not allowed"})
+		return
+	}
+	s.serialize(w, func() {
+		c, err := s.discover(r)
+		if err != nil {
+			writeJson(w, http.StatusBadGateway, operationResult{Error: err.Error()})
+			return
+		}
+
+		sc := c.selectScenario()
+		if sc.Name != "failover" {
+			result := operationResult{Scenario: &sc, Error: "clusters are not in a state that allows a planned failover"}
+			writeJson(w, http.StatusConflict, result)
+			return
+		}
+
+		steps := c.failoverSteps(true, true)
+		result := operationResult{Scenario: &sc, Steps: steps, DryRun: c.ClientConfig.DryRun}
+		if c.ClientConfig.DryRun {
+			writeJson(w, http.StatusOK, result)
+			return
+		}
+		err = c.execute(steps)
+		result.Executed = true
+		if err != nil {
+			result.Error = err.Error()
+			writeJson(w, http.StatusInternalServerError, result)
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	})
+}
+
+// Build the TLS configuration for the API listener, requiring client
+// certificates when a client CA is configured
+func (s *server) tlsConfig() (*tls.Config, error) {
+	if s.tlsClientCAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(s.tlsClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", s.tlsClientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// Register the control API endpoints
+func (s *server) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/topology", s.authenticate(s.handleTopology))
+	mux.HandleFunc("/v1/evaluate", s.authenticate(s.handleEvaluate))
+	mux.HandleFunc("/v1/failover", s.authenticate(s.handleFailover))
+	mux.HandleFunc("/v1/witness", s.authenticate(s.handleWitness))
+}
+
+// Serve the control API until the context is cancelled
+func (s *server) run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	s.routes(mux)
+	return s.listen(ctx, "control API", mux)
+}
+
+// Serve a handler on the listen address until the context is cancelled
+func (s *server) listen(ctx context.Context, name string, handler http.Handler) error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Stopping", name)
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Serving %s on %s", name, s.listenAddr)
+	if s.tlsCertFile != "" {
+		err = srv.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Serve the HTTP control API
+func runServe(args []string) {
+	s := server{}
+	fs := s.config.newFlagSet("serve")
+	fs.StringVar(&s.listenAddr, "listenAddr", "127.0.0.1:9090", "Address on which to serve the control API")
+	fs.StringVar(&s.apiToken, "apiToken", os.Getenv("VAULT_FM_API_TOKEN"), "Shared secret required as a bearer token on API requests (defaults to $VAULT_FM_API_TOKEN)")
+	fs.StringVar(&s.tlsCertFile, "tlsCertFile", "", "TLS certificate file for the control API listener")
+	fs.StringVar(&s.tlsKeyFile, "tlsKeyFile", "", "TLS key file for the control API listener")
+	fs.StringVar(&s.tlsClientCAFile, "tlsClientCAFile", "", "CA file used to verify client certificates (enables mutual TLS)")
+	s.config.ClientConfig.Unattended = true
+	s.config.parseFlags(fs, args)
+
+	if s.apiToken == "" && s.tlsClientCAFile == "" {
+		log.Fatalln("The control API requires authentication - set -apiToken and/or -tlsClientCAFile")
+	}
+	if s.tlsClientCAFile != "" && s.tlsCertFile == "" {
+		log.Fatalln("Mutual TLS requires -tlsCertFile and -tlsKeyFile to be set")
+	}
+	if (s.tlsCertFile == "") != (s.tlsKeyFile == "") {
+		log.Fatalln("Both -tlsCertFile and -tlsKeyFile must be set to serve TLS")
+	}
+
+	c := s.config.fresh()
+	err := c.refresh()
+	if err != nil {
+		log.Printf("WARN: initial discovery failed: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = s.run(ctx)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Collects key shares for a DR operation token generation attempt from key
+// holders on their own machines
+type shareServer struct {
+	server
+	cluster        *ClusterData
+	cancelExisting bool
+	nonce          string
+	otp            string
+	attempt        operationTokenAttempt
+	// serializes share submissions against the attempt
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// The progress of the generation attempt reported to key holders. The encoded
+// token is never included.
+type shareProgress struct {
+	Nonce    string `json:"nonce"`
+	Progress int    `json:"progress"`
+	Required int    `json:"required"`
+	Complete bool   `json:"complete"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Return the current progress of the generation attempt
+func (s *shareServer) progress() shareProgress {
+	return shareProgress{
+		Nonce:    s.nonce,
+		Progress: s.attempt.Progress,
+		Required: s.attempt.Required,
+		Complete: s.attempt.Complete,
+	}
+}
+
+// Open a new generation attempt on the DR secondary, cancelling any attempt
+// already in progress if configured to do so
+func (s *shareServer) start(pgpKey string) error {
+	attempt, err := readOperationTokenAttempt(s.cluster.Client)
+	if err != nil {
+		return err
+	}
+	if attempt.Started {
+		if !s.cancelExisting {
+			return fmt.Errorf("an operation token generation attempt is already in progress on %s - set -cancelExisting to replace it", s.cluster.Addr)
+		}
+		err = cancelOperationTokenAttempt(s.cluster.Client)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.attempt, err = startOperationTokenAttempt(s.cluster.Client, pgpKey)
+	if err != nil {
+		return err
+	}
+	s.nonce, s.otp = s.attempt.Nonce, s.attempt.Otp
+	log.Printf("Started operation token generation on %s (nonce %s): %d key shares required", s.cluster.Addr, s.nonce, s.attempt.Required)
+	return nil
+}
+
+// Handle GET /v1/shares by reporting progress, and POST /v1/shares by
+// submitting a single key share
+func (s *shareServer) handleShares(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, s.progress())
+	case http.MethodPost:
+		if s.attempt.Complete {
+			writeJson(w, http.StatusConflict, shareProgress{Error: "the key share threshold has already been reached"})
+			return
+		}
+		var req struct {
+			Key string `json:"key"`
+		}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+		if err != nil || strings.TrimSpace(req.Key) == "" {
+			writeJson(w, http.StatusBadRequest, shareProgress{Error: "request body must be a JSON object with a key share"})
+			return
+		}
+
+		attempt, err := submitOperationTokenShare(s.cluster.Client, s.nonce, strings.TrimSpace(req.Key))
+		if err != nil {
+			log.Printf("Key share from %s rejected: %v", r.RemoteAddr, err)
+			progress := s.progress()
+			progress.Error = "key share rejected"
+			writeJson(w, http.StatusBadRequest, progress)
+			return
+		}
+		s.attempt = attempt
+		if s.attempt.Complete {
+			log.Printf("Key share from %s accepted - threshold reached", r.RemoteAddr)
+			close(s.done)
+		} else {
+			log.Printf("Key share from %s accepted (%d/%d)", r.RemoteAddr, s.attempt.Progress, s.attempt.Required)
+		}
+		writeJson(w, http.StatusOK, s.progress())
+	default:
+		writeJson(w, http.StatusMethodNotAllowed, shareProgress{Error: "method not allowed"})
+	}
+}
+
+// Hand the completed token to the operator running the server. Only the
+// operator holds the OTP or PGP private key needed to decode it.
+func (s *shareServer) handOver() {
+	fmt.Println("Encoded DR operation token:", s.attempt.EncodedToken)
+	if s.otp != "" {
+		fmt.Println("OTP:", s.otp)
+		fmt.Println("Decode the token with: vault operator generate-root -dr-token -decode=<encoded token> -otp=<OTP>")
+	} else {
+		fmt.Printf("The token is encrypted with PGP key %s; decrypt it with: echo <encoded token> | base64 -d | gpg -dq\n", s.attempt.PgpFingerprint)
+	}
+}
+
+// Serve the key share submission endpoint until the key share threshold is
+// reached or the context is cancelled
+func (s *shareServer) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/shares", s.authenticate(s.handleShares))
+	err := s.listen(ctx, "key share submission", mux)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-s.done:
+		s.handOver()
+		return nil
+	default:
+		return fmt.Errorf("stopped before the key share threshold was reached")
+	}
+}
+
+// Collect key shares for DR operation token generation from multiple key
+// holders
+func runShares(args []string) {
+	if len(args) == 0 || args[0] != "serve" {
+		fmt.Fprintf(os.Stderr, "Usage: vault-fm-operator shares serve [flags]\n")
+		os.Exit(2)
+	}
+
+	s := shareServer{done: make(chan struct{})}
+	fs := s.config.newFlagSet("shares serve")
+	fs.StringVar(&s.listenAddr, "listenAddr", "127.0.0.1:9443", "Address on which to serve the key share submission endpoint")
+	fs.StringVar(&s.apiToken, "apiToken", os.Getenv("VAULT_FM_SHARES_TOKEN"), "Shared secret required as a bearer token from key holders (defaults to $VAULT_FM_SHARES_TOKEN)")
+	fs.StringVar(&s.tlsCertFile, "tlsCertFile", "", "TLS certificate file for the key share listener")
+	fs.StringVar(&s.tlsKeyFile, "tlsKeyFile", "", "TLS key file for the key share listener")
+	fs.StringVar(&s.tlsClientCAFile, "tlsClientCAFile", "", "CA file used to verify key holder certificates (enables mutual TLS)")
+	fs.StringVar(&s.config.ClientConfig.RecoveryPgpKeyFile, "recoveryPgpKey", "", "File containing a base64-encoded PGP public key used to encrypt the DR operation token (an OTP is used if empty)")
+	fs.BoolVar(&s.cancelExisting, "cancelExisting", false, "Cancel a generation attempt that is already in progress")
+	s.config.setup(fs, args[1:])
+
+	if s.config.ClientConfig.Mode != "dr" {
+		log.Fatalln("Key share submission is only supported for DR replication")
+	}
+	if s.tlsCertFile == "" || s.tlsKeyFile == "" {
+		log.Fatalln("Key shares must be submitted over TLS - set -tlsCertFile and -tlsKeyFile")
+	}
+	if s.apiToken == "" && s.tlsClientCAFile == "" {
+		log.Fatalln("Key share submission requires authentication - set -apiToken and/or -tlsClientCAFile")
+	}
+	s.cluster = &s.config.SecondaryCluster
+	if s.cluster.Client == nil || !s.cluster.Follower {
+		log.Fatalln("A healthy DR secondary cluster is required to generate a DR operation token")
+	}
+
+	pgpKey, err := s.config.recoveryPgpKey()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	err = s.start(pgpKey)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = s.run(ctx)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// The reported replication state of a peer cluster, as seen from a cluster
+type PeerStatus struct {
+	APIAddress       string    `json:"apiAddress" yaml:"apiAddress"`
+	ClusterAddress   string    `json:"clusterAddress" yaml:"clusterAddress"`
+	ConnectionStatus string    `json:"connectionStatus" yaml:"connectionStatus"`
+	LastHeartbeat    time.Time `json:"lastHeartbeat" yaml:"lastHeartbeat"`
+	HeartbeatAge     string    `json:"heartbeatAge,omitempty" yaml:"heartbeatAge,omitempty"`
+	ClockSkewMs      string    `json:"clockSkewMs,omitempty" yaml:"clockSkewMs,omitempty"`
+}
+
+// The reported state of a single configured cluster
+type ClusterStatus struct {
+	Addr          string        `json:"addr" yaml:"addr"`
+	Reachable     bool          `json:"reachable" yaml:"reachable"`
+	Name          string        `json:"clusterName,omitempty" yaml:"clusterName,omitempty"`
+	Role          string        `json:"role,omitempty" yaml:"role,omitempty"`
+	ClusterID     string        `json:"clusterId,omitempty" yaml:"clusterId,omitempty"`
+	State         string        `json:"state,omitempty" yaml:"state,omitempty"`
+	Initialized   bool          `json:"initialized" yaml:"initialized"`
+	Sealed        bool          `json:"sealed" yaml:"sealed"`
+	LastWal       int           `json:"lastWal" yaml:"lastWal"`
+	LastRemoteWal int           `json:"lastRemoteWal,omitempty" yaml:"lastRemoteWal,omitempty"`
+	TokenValid    bool          `json:"tokenValid" yaml:"tokenValid"`
+	TokenCheck    string        `json:"tokenCheck,omitempty" yaml:"tokenCheck,omitempty"`
+	Peers         []PeerStatus  `json:"peers,omitempty" yaml:"peers,omitempty"`
+	Health        []healthIssue `json:"health,omitempty" yaml:"health,omitempty"`
+}
+
+// The reported state of the replication topology. Secrets are always redacted.
+type StatusReport struct {
+	Mode                     string          `json:"mode" yaml:"mode"`
+	DiscoveredAt             time.Time       `json:"discoveredAt" yaml:"discoveredAt"`
+	Conflict                 string          `json:"conflict,omitempty" yaml:"conflict,omitempty"`
+	OpBatchToken             string          `json:"opBatchToken,omitempty" yaml:"opBatchToken,omitempty"`
+	OpBatchTokenValid        bool            `json:"opBatchTokenValid" yaml:"opBatchTokenValid"`
+	OpBatchTokenVerifiedBy   []string        `json:"opBatchTokenVerifiedBy,omitempty" yaml:"opBatchTokenVerifiedBy,omitempty"`
+	SecondaryActivationToken string          `json:"secondaryActivationToken,omitempty" yaml:"secondaryActivationToken,omitempty"`
+	Clusters                 []ClusterStatus `json:"clusters" yaml:"clusters"`
+}
+
+// A replication peer, as reported in a replication status response
+type replicationPeer struct {
+	APIAddress       string    `json:"api_address"`
+	ClusterAddress   string    `json:"cluster_address"`
+	ConnectionStatus string    `json:"connection_status"`
+	LastHeartbeat    time.Time `json:"last_heartbeat"`
+	ClockSkewMs      string    `json:"clock_skew_ms"`
+	// reported as strings of milliseconds
+	LastHeartbeatDurationMs       string `json:"last_heartbeat_duration_ms"`
+	ReplicationPrimaryCanaryAgeMs string `json:"replication_primary_canary_age_ms"`
+}
+
+// The subset of a replication status response used for status reporting and metrics
+type replicationStatus struct {
+	ClusterID                string            `json:"cluster_id"`
+	State                    string            `json:"state"`
+	CorruptedMerkleTree      bool              `json:"corrupted_merkle_tree"`
+	LastCorruptionCheckEpoch string            `json:"last_corruption_check_epoch"`
+	ReindexInProgress        bool              `json:"reindex_in_progress"`
+	ReindexStage             string            `json:"reindex_stage"`
+	ReindexBuildingProgress  int               `json:"reindex_building_progress"`
+	ReindexBuildingTotal     int               `json:"reindex_building_total"`
+	LastRemoteWal            int               `json:"last_remote_wal"`
+	Primaries                []replicationPeer `json:"primaries"`
+	Secondaries              []replicationPeer `json:"secondaries"`
+}
+
+// Return a redacted placeholder for a secret, or an empty string if unset
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// Build a status report from the discovered topology
+func (c *ConfigData) getStatusReport() StatusReport {
+	report := StatusReport{
+		Mode:                     c.Topology.Mode,
+		DiscoveredAt:             c.Topology.DiscoveredAt,
+		Conflict:                 c.Conflict,
+		OpBatchToken:             redact(c.ClientConfig.OpBatchToken),
+		OpBatchTokenValid:        c.tokenValid(),
+		OpBatchTokenVerifiedBy:   c.Topology.tokenVerifiedBy(),
+		SecondaryActivationToken: redact(c.SecondaryActivationToken),
+	}
+
+	discovered := map[string]ClusterSnapshot{}
+	for _, snapshot := range c.Topology.Clusters {
+		discovered[snapshot.Addr] = snapshot
+	}
+
+	for _, addr := range strings.Split(c.ClientConfig.ConfiguredAddrs, ",") {
+		snapshot, ok := discovered[addr]
+		if !ok {
+			report.Clusters = append(report.Clusters, ClusterStatus{Addr: addr})
+			continue
+		}
+
+		status := ClusterStatus{
+			Addr:        addr,
+			Reachable:   true,
+			Name:        snapshot.Name,
+			Role:        snapshot.ReplicationMode,
+			State:       snapshot.ReplicationState,
+			Initialized: snapshot.Initialized,
+			Sealed:      snapshot.Sealed,
+			LastWal:     int(snapshot.LastWal),
+			TokenValid:  snapshot.TokenValid,
+			TokenCheck:  snapshot.TokenCheck,
+			Health:      c.healthIssues(snapshot),
+		}
+
+		var repStatus replicationStatus
+		if len(snapshot.Replication) > 0 && json.Unmarshal(snapshot.Replication, &repStatus) == nil {
+			status.ClusterID = repStatus.ClusterID
+			status.LastRemoteWal = repStatus.LastRemoteWal
+			for _, peer := range append(repStatus.Primaries, repStatus.Secondaries...) {
+				peerStatus := PeerStatus{
+					APIAddress:       peer.APIAddress,
+					ClusterAddress:   peer.ClusterAddress,
+					ConnectionStatus: peer.ConnectionStatus,
+					LastHeartbeat:    peer.LastHeartbeat,
+					ClockSkewMs:      peer.ClockSkewMs,
+				}
+				if !peer.LastHeartbeat.IsZero() {
+					peerStatus.HeartbeatAge = c.Topology.DiscoveredAt.Sub(peer.LastHeartbeat).Round(time.Millisecond).String()
+				}
+				status.Peers = append(status.Peers, peerStatus)
+			}
+		}
+
+		report.Clusters = append(report.Clusters, status)
+	}
+
+	return report
+}
+
+// Write a status report in the given output format ('json', 'yaml' or 'table')
+func writeStatusReport(w io.Writer, report StatusReport, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(report)
+	case "table":
+		return writeStatusTable(w, report)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// Write a status report as a human-readable table
+func writeStatusTable(w io.Writer, report StatusReport) error {
+	fmt.Fprintf(w, "Mode: %s\n", report.Mode)
+	fmt.Fprintf(w, "Discovered at: %s\n", report.DiscoveredAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Operation batch token: valid=%t verified by=%s\n", report.OpBatchTokenValid, strings.Join(report.OpBatchTokenVerifiedBy, ","))
+	if report.Conflict != "" {
+		fmt.Fprintf(w, "Conflict: multiple %s clusters\n", report.Conflict)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tNAME\tROLE\tSTATE\tCLUSTER ID\tLAST WAL\tLAST REMOTE WAL\tCONNECTION\tHEARTBEAT AGE\tSEALED\tTOKEN\tHEALTH")
+	for _, cluster := range report.Clusters {
+		if !cluster.Reachable {
+			fmt.Fprintf(tw, "%s\t-\tunreachable\t-\t-\t-\t-\t-\t-\t-\t-\t-\n", cluster.Addr)
+			continue
+		}
+		var connections, ages []string
+		for _, peer := range cluster.Peers {
+			connections = append(connections, peer.ConnectionStatus)
+			ages = append(ages, peer.HeartbeatAge)
+		}
+		health := "ok"
+		if len(cluster.Health) > 0 {
+			var checks []string
+			for _, issue := range cluster.Health {
+				checks = append(checks, issue.Check+"("+issue.Peer+")")
+			}
+			health = strings.Join(checks, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%t\t%s\t%s\n",
+			cluster.Addr, cluster.Name, cluster.Role, cluster.State, cluster.ClusterID,
+			cluster.LastWal, cluster.LastRemoteWal, strings.Join(connections, ","), strings.Join(ages, ","),
+			cluster.Sealed, cluster.TokenCheck, health)
+	}
+	return tw.Flush()
+}
@@ -7,20 +7,77 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 )
 
-// Revoke the secondary token on the primary cluster
-func (c *ConfigData) revokeSecondary(revokeAddr string, client *http.Client) error {
-	log.Println("Revoking secondary token for cluster", revokeAddr)
+// A point-in-time view of a single cluster, as discovered at its address
+type ClusterSnapshot struct {
+	Addr              string          `json:"addr"`
+	Name              string          `json:"clusterName,omitempty"`
+	Initialized       bool            `json:"initialized"`
+	Sealed            bool            `json:"sealed"`
+	LeaderClusterAddr string          `json:"leaderClusterAddr,omitempty"`
+	ReplicationMode   string          `json:"replicationMode"`
+	ReplicationState  string          `json:"replicationState,omitempty"`
+	LastWal           float64         `json:"lastWal"`
+	Replication       json.RawMessage `json:"replication,omitempty"`
+	TokenValid        bool            `json:"tokenValid"`
+	TokenCheck        string          `json:"tokenCheck,omitempty"`
+	TokenType         string          `json:"tokenType,omitempty"`
+	TokenTTL          int64           `json:"tokenTtl,omitempty"`
+}
+
+// The response to a token lookup-self request
+type tokenLookup struct {
+	Data struct {
+		Type string `json:"type"`
+		// remaining TTL in seconds, or 0 for a token that does not expire
+		TTL int64 `json:"ttl"`
+	} `json:"data"`
+}
+
+// The outcome of checking the operation token against a cluster
+const (
+	// lookup-self succeeded on the cluster
+	tokenVerified = "verified"
+	// lookup-self was rejected by the cluster
+	tokenRejected = "rejected"
+	// the token was verified as a batch token against the DR primary, whose
+	// token store the DR secondary shares, so it is usable as a dr_operation_token
+	tokenDrOperation = "dr-operation-token"
+	// the token could not be checked against the cluster
+	tokenUnverified = "unverified"
+	// a DR operation batch token was supplied but no DR primary was reachable
+	// to look it up against, so it is used unverified
+	tokenDrSupplied = "dr-operation-token-supplied"
+)
+
+// An immutable snapshot of the replication topology. Discovery only reads from
+// the clusters; any action taken in response happens in the evaluation phase.
+type Topology struct {
+	Mode         string            `json:"mode"`
+	DiscoveredAt time.Time         `json:"discoveredAt"`
+	Clusters     []ClusterSnapshot `json:"clusters"`
+}
+
+// Revoke the secondary token with the given ID on the primary cluster
+func (c *ConfigData) revokeSecondary(revokeAddr string, client *http.Client, id string) error {
+	log.Println("Revoking secondary token", id, "for cluster", revokeAddr)
 
-	m, b := map[string]interface{}{"id": "secondary-token"}, new(bytes.Buffer)
+	m, b := map[string]interface{}{"id": id}, new(bytes.Buffer)
 	json.NewEncoder(b).Encode(m)
 
+	token, err := c.tokenFor(revokeAddr)
+	if err != nil {
+		return err
+	}
 	req, err := http.NewRequest("POST", revokeAddr+"/v1"+replicationPath+c.ClientConfig.Mode+"/primary/revoke-secondary", b)
-	req.Header.Set(vaultTokenHeader, c.ClientConfig.OpBatchToken)
 	if err != nil {
 		return err
 	}
+	req.Header.Set(vaultTokenHeader, token)
 
 	_, err = client.Do(req)
 	if err != nil {
@@ -39,246 +96,436 @@ func (c *ConfigData) getHttpClient() *http.Client {
 	return client
 }
 
-// Resolve a primary conflict by demoting the primary with the lowest WAL
-// Note that this may not always be the correct resolution, as the primary with
-// the lowest WAL may not universally be the best choice for demotion
-func (c *ConfigData) resolvePrimaryConflict(haveHighestWal bool, addr string, client *http.Client) error {
-	var demoteAddr string
-	var revokeAddr string
-
-	if !haveHighestWal {
-		demoteAddr = addr
-		revokeAddr = c.PrimaryCluster.Addr
-		c.SecondaryCluster.Addr = addr
-	} else {
-		demoteAddr = c.PrimaryCluster.Addr
-		revokeAddr = addr
-		c.PrimaryCluster.Addr = addr
-		c.SecondaryCluster.Addr = c.PrimaryCluster.Addr
-	}
-
-	req, err := http.NewRequest("POST", demoteAddr+"/v1"+replicationPath+c.ClientConfig.Mode+"/primary/demote", nil)
-	req.Header.Set(vaultTokenHeader, c.ClientConfig.OpBatchToken)
+// Issue a GET request against the Vault API at addr and decode the JSON
+// response body, regardless of the response status code
+func getJson(client *http.Client, addr string, path string, token string, v interface{}) (int, error) {
+	req, err := http.NewRequest("GET", addr+"/v1"+path, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = client.Do(req)
-	if err != nil {
-		return fmt.Errorf("multiple primary resolution attempt failed: %w", err)
-	}
-	log.Printf("Demoted cluster %s and with lowest WAL, attempting to heal replication connection", demoteAddr)
-	c.SecondaryCluster.Addr = demoteAddr
-	c.initClient(c.PrimaryCluster.Addr)
-	c.initClient(c.SecondaryCluster.Addr)
-	c.waitForSecondary(true)
-	c.revokeSecondary(revokeAddr, client)
-	err = c.getActivationToken(c.PrimaryCluster.Client)
-	if err != nil {
-		return fmt.Errorf("get activation token: %w", err)
+	if token != "" {
+		req.Header.Set(vaultTokenHeader, token)
 	}
-	err = c.updatePrimary(c.SecondaryCluster.Client, true)
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer resp.Body.Close()
 
-	return nil
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding response from %s: %w", path, err)
+		}
+	}
+	return resp.StatusCode, nil
 }
 
-// Resolve a secondary conflict by promoting the secondary with the highest WAL
-func (c *ConfigData) resolveSecondaryConflict(haveHighestWal bool, addr string, client *http.Client) error {
-	// find secondary with highest WAL and promote it
-	// get secondary activation token
-	// update-primary on secondary
+// Discover the replication status, health and leader information of the
+// cluster at a single address
+func (c *ConfigData) getClusterSnapshot(addr string, client *http.Client) (ClusterSnapshot, error) {
+	snapshot := ClusterSnapshot{Addr: addr}
 
-	if !haveHighestWal {
-		c.PrimaryCluster.Addr = c.SecondaryCluster.Addr
-		c.SecondaryCluster.Addr = addr
-	} else {
-		c.PrimaryCluster.Addr = addr
+	var repStatus struct {
+		Data json.RawMessage `json:"data"`
 	}
+	status, err := getJson(client, addr, replicationPath+c.ClientConfig.Mode+"/status", "", &repStatus)
+	if err != nil || status != 200 {
+		return snapshot, fmt.Errorf("topology discovery failed for %s: status %d: %v", addr, status, err)
+	}
+	snapshot.Replication = repStatus.Data
 
-	req, err := http.NewRequest("POST", c.PrimaryCluster.Addr+"/v1"+replicationPath+c.ClientConfig.Mode+"/secondary/promote", nil)
-	req.Header.Set(vaultTokenHeader, c.ClientConfig.OpBatchToken)
-	if err != nil {
-		return err
+	var repBase struct {
+		Mode    string   `json:"mode"`
+		State   string   `json:"state"`
+		LastWal *float64 `json:"last_wal"`
 	}
-	_, err = client.Do(req)
+	err = json.Unmarshal(repStatus.Data, &repBase)
+	if err != nil || repBase.Mode == "" {
+		return snapshot, fmt.Errorf("could not determine replication mode for %s", addr)
+	}
+	snapshot.ReplicationMode = repBase.Mode
+	snapshot.ReplicationState = repBase.State
+	if repBase.LastWal != nil {
+		snapshot.LastWal = *repBase.LastWal
+	}
+
+	var health struct {
+		Initialized bool   `json:"initialized"`
+		Sealed      bool   `json:"sealed"`
+		ClusterName string `json:"cluster_name"`
+	}
+	_, err = getJson(client, addr, "/sys/health", "", &health)
 	if err != nil {
-		return fmt.Errorf("multiple secondary resolution attempt failed: %w", err)
+		log.Printf("WARN: could not read health status for %s: %v", addr, err)
+	} else {
+		snapshot.Initialized = health.Initialized
+		snapshot.Sealed = health.Sealed
+		snapshot.Name = health.ClusterName
+	}
+
+	var leader struct {
+		LeaderClusterAddress string `json:"leader_cluster_address"`
 	}
-	log.Printf("Promoted cluster %s and with highest WAL, attempting to heal replication connection", c.PrimaryCluster.Addr)
-	c.initClient(c.PrimaryCluster.Addr)
-	c.initClient(c.SecondaryCluster.Addr)
-	err = c.getActivationToken(c.PrimaryCluster.Client)
+	_, err = getJson(client, addr, "/sys/leader", "", &leader)
 	if err != nil {
-		return fmt.Errorf("get activation token: %w", err)
+		log.Printf("WARN: could not read leader status for %s: %v", addr, err)
+	} else {
+		snapshot.LeaderClusterAddr = leader.LeaderClusterAddress
 	}
-	err = c.updatePrimary(c.SecondaryCluster.Client, true)
+
+	// DR secondaries reject token lookups, so the token's usability as a
+	// dr_operation_token is derived from the DR primary once it is discovered
+	if c.ClientConfig.Mode == "dr" && snapshot.ReplicationMode == "secondary" {
+		snapshot.TokenCheck = tokenUnverified
+		return snapshot, nil
+	}
+
+	// use the cluster's operation token, or the token obtained by logging in,
+	// to lookup-self
+	token, err := c.tokenForCluster(addr, snapshot.Name)
 	if err != nil {
-		return err
+		log.Printf("WARN: %v", err)
+	}
+	var lookup tokenLookup
+	status, err = getJson(client, addr, "/auth/token/lookup-self", token, &lookup)
+	snapshot.TokenValid = token != "" && err == nil && status == 200
+	snapshot.TokenCheck = tokenRejected
+	if snapshot.TokenValid {
+		snapshot.TokenCheck = tokenVerified
+		snapshot.TokenType = lookup.Data.Type
+		// tokens obtained by logging in are replaced before they expire, so
+		// only the TTL of a given operation token is recorded
+		if c.ClientConfig.authenticator == nil {
+			snapshot.TokenTTL = lookup.Data.TTL
+		}
 	}
 
-	return nil
+	return snapshot, nil
 }
 
-// Assign the primary and secondary cluster addresses based on the discovered topology
-func (c *ConfigData) getTopology(verifiedAddrs []string) error {
-	for _, addr := range verifiedAddrs {
-		client := c.getHttpClient()
+// Discover the replication topology of the clusters at the verified addresses.
+// This only reads cluster state and does not modify the clusters or c, apart
+// from caching any token obtained by logging in.
+func (c *ConfigData) getTopology(verifiedAddrs []string) (Topology, error) {
+	t := Topology{
+		Mode:         c.ClientConfig.Mode,
+		DiscoveredAt: time.Now(),
+	}
+	client := c.getHttpClient()
 
-		req, err := http.NewRequest("GET", addr+"/v1/auth/token/lookup-self", nil)
+	for _, addr := range verifiedAddrs {
+		snapshot, err := c.getClusterSnapshot(addr, client)
 		if err != nil {
-			return fmt.Errorf("error lookup-self: %w", err)
+			return t, err
 		}
+		t.Clusters = append(t.Clusters, snapshot)
+	}
+	c.confirmDrOperationTokens(&t, client)
 
-		// use the operation batch token to lookup-self
-		// we should only do this if we haven't already verified the token, otherwise we could overwrite a verified status
-		if !c.OpBatchTokenVerified {
-			req.Header.Set(vaultTokenHeader, c.ClientConfig.OpBatchToken)
-			resp, err := client.Do(req)
-			if err != nil || resp.StatusCode != 200 {
-				c.OpBatchTokenValid = false
-			} else if resp.StatusCode == 200 {
-				c.OpBatchTokenValid = true
-				c.OpBatchTokenVerified = true
+	if verified := t.tokenVerifiedBy(); len(verified) > 0 {
+		log.Println("Operation token verified against:", strings.Join(verified, ", "))
+	} else {
+		log.Println("Operation token could not be verified against any cluster")
+	}
+	log.Println("Topology discovery complete")
+	return t, nil
+}
+
+// Confirm that the token passed to each DR secondary is usable as a
+// dr_operation_token. A DR secondary shares its DR primary's token store but
+// rejects token lookups, so the token is looked up against the DR primary
+// instead and must be a batch token. Without a reachable DR primary the token
+// cannot be confirmed, so a supplied DR operation batch token is used
+// unverified; only without one is the token invalid.
+func (c *ConfigData) confirmDrOperationTokens(t *Topology, client *http.Client) {
+	if t.Mode != "dr" {
+		return
+	}
+	token, err := c.drOperationToken()
+	if err != nil {
+		return
+	}
+	var primaries []string
+	for _, primary := range t.Clusters {
+		if primary.ReplicationMode == "primary" && !primary.Sealed {
+			primaries = append(primaries, primary.Addr)
+		}
+	}
+
+	for i, secondary := range t.Clusters {
+		if secondary.ReplicationMode != "secondary" {
+			continue
+		}
+		if len(primaries) == 0 {
+			log.Printf("WARN: no DR primary is reachable to confirm the DR operation batch token - using it unverified on %s", secondary.Addr)
+			t.Clusters[i].TokenValid = true
+			t.Clusters[i].TokenCheck = tokenDrSupplied
+			continue
+		}
+		for _, addr := range primaries {
+			var lookup tokenLookup
+			status, err := getJson(client, addr, "/auth/token/lookup-self", token, &lookup)
+			if err == nil && status == 200 && lookup.Data.Type == "batch" {
+				t.Clusters[i].TokenValid = true
+				t.Clusters[i].TokenCheck = tokenDrOperation
+				t.Clusters[i].TokenType = lookup.Data.Type
+				t.Clusters[i].TokenTTL = lookup.Data.TTL
+				break
 			}
 		}
+	}
+}
 
-		resp, err := client.Get(addr + "/v1" + replicationPath + c.ClientConfig.Mode + "/status")
-		if err != nil || resp.StatusCode != 200 {
-			return fmt.Errorf("topology discovery failed: %w", err)
+// Return the addresses of the clusters against which the operation token was
+// verified with lookup-self
+func (t Topology) tokenVerifiedBy() []string {
+	var addrs []string
+	for _, snapshot := range t.Clusters {
+		if snapshot.TokenCheck == tokenVerified {
+			addrs = append(addrs, snapshot.Addr)
 		}
-		defer resp.Body.Close()
+	}
+	return addrs
+}
 
-		var data map[string]interface{}
-		decoder := json.NewDecoder(resp.Body)
-		err = decoder.Decode(&data)
-		if err != nil {
-			log.Printf("error decoding topology json: %v", err)
+// Return the discovered clusters in the given replication mode, ordered by
+// highest WAL first
+func (t Topology) clustersInMode(mode string) []ClusterSnapshot {
+	var clusters []ClusterSnapshot
+	for _, cluster := range t.Clusters {
+		if cluster.ReplicationMode == mode {
+			clusters = append(clusters, cluster)
 		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].LastWal > clusters[j].LastWal
+	})
+	return clusters
+}
 
-		var repMode string
-		if v, ok := data["data"].(map[string]interface{})["mode"]; ok {
-			repMode = v.(string)
-		} else {
-			return fmt.Errorf("could not determine replication mode for %s", addr)
+// Order secondary clusters by their suitability for promotion: healthy
+// clusters first, then clusters connected to their primary, then by the highest
+// WAL received from the primary. Clusters that tie retain their existing order.
+func rankSecondaries(secondaries []ClusterSnapshot) []ClusterSnapshot {
+	type rank struct {
+		healthy   bool
+		connected bool
+		remoteWal int
+	}
+	ranks := map[string]rank{}
+	for _, snapshot := range secondaries {
+		r := rank{healthy: snapshot.Initialized && !snapshot.Sealed}
+		var repStatus replicationStatus
+		if len(snapshot.Replication) > 0 && json.Unmarshal(snapshot.Replication, &repStatus) == nil {
+			r.remoteWal = repStatus.LastRemoteWal
+			for _, peer := range repStatus.Primaries {
+				if snapshot.ReplicationState == "stream-wals" && peer.ConnectionStatus == "connected" {
+					r.connected = true
+				}
+			}
 		}
+		ranks[snapshot.Addr] = r
+	}
 
-		var lastWal float64
-		if v, ok := data["data"].(map[string]interface{})["last_wal"]; ok {
-			lastWal = v.(float64)
-		} else {
-			lastWal = 0
+	sort.SliceStable(secondaries, func(i, j int) bool {
+		a, b := ranks[secondaries[i].Addr], ranks[secondaries[j].Addr]
+		if a.healthy != b.healthy {
+			return a.healthy
 		}
+		if a.connected != b.connected {
+			return a.connected
+		}
+		return a.remoteWal > b.remoteWal
+	})
+	return secondaries
+}
+
+// Assign the primary and secondary clusters based on a discovered topology
+// snapshot. The best promotion candidate is assigned as the secondary cluster
+// and any other secondaries are kept as additional secondaries. When multiple
+// clusters report the same replication mode, the conflict is recorded so that it
+// can be resolved in the evaluation phase: for multiple primaries, the primary
+// with the highest WAL is retained and the others are assigned as secondaries to
+// be demoted; when every configured cluster is a secondary, the best promotion
+// candidate is assigned as the primary to be promoted.
+func (c *ConfigData) applyTopology(t Topology) error {
+	c.Topology = t
+
+	primaries := t.clustersInMode("primary")
+	secondaries := rankSecondaries(t.clustersInMode("secondary"))
+	configured := strings.Split(c.ClientConfig.ConfiguredAddrs, ",")
 
-		var haveHighestWal bool
-		if lastWal > c.HighestWal {
-			c.HighestWal = lastWal
-			haveHighestWal = true
+	var primary *ClusterSnapshot
+	var others []ClusterSnapshot
+	switch {
+	case len(primaries) > 1:
+		log.Println("Multiple primary clusters detected - conflict resolution is required")
+		c.Conflict = "primary"
+		primary = &primaries[0]
+		others = append(append(others, primaries[1:]...), secondaries...)
+	case len(primaries) == 0 && len(secondaries) > 1 && len(t.Clusters) == len(configured):
+		log.Println("Multiple secondary clusters detected - conflict resolution is required")
+		c.Conflict = "secondary"
+		primary = &secondaries[0]
+		others = secondaries[1:]
+	default:
+		if len(primaries) == 1 {
+			primary = &primaries[0]
 		}
+		others = secondaries
+	}
 
-		switch c.ClientConfig.Mode {
-		case "dr":
-			if repMode != "disabled" {
-				switch repMode {
-				case "primary":
-					if c.PrimaryCluster.Addr != "" {
-						log.Println("Multiple primary clusters detected - attempting to resolve conflict")
-						err = c.resolvePrimaryConflict(haveHighestWal, addr, client)
-						if err != nil {
-							return err
-						}
-					}
-					c.PrimaryCluster.Addr = addr
-					data, _ := json.Marshal(data["data"])
-					err = json.Unmarshal(data, &c.PrimaryDrConfig)
-					if err != nil {
-						return err
-					}
-					if c.PrimaryDrConfig.Mode == "primary" && c.PrimaryDrConfig.State == "running" {
-						c.PrimaryCluster.Leader = true
-					}
-				case "secondary":
-					if c.SecondaryCluster.Addr != "" {
-						log.Println("Multiple secondary clusters detected - attempting to resolve conflict")
-						err = c.resolveSecondaryConflict(haveHighestWal, addr, client)
-						if err != nil {
-							return err
-						}
-					}
-					c.SecondaryCluster.Addr = addr
-					data, _ := json.Marshal(data["data"])
-					err = json.Unmarshal(data, &c.SecondaryDrConfig)
-					if err != nil {
-						return err
-					}
-					if c.SecondaryDrConfig.Mode == "secondary" {
-						c.SecondaryCluster.Follower = true
-						if c.SecondaryDrConfig.State == "stream-wals" {
-							for primary := range c.SecondaryDrConfig.Primaries {
-								if c.SecondaryDrConfig.Primaries[primary].ConnectionStatus == "connected" {
-									c.SecondaryCluster.Connected = true
-									break
-								}
-							}
-						} else {
-							c.SecondaryCluster.Connected = false
+	for _, cluster := range t.Clusters {
+		if cluster.LastWal > c.HighestWal {
+			c.HighestWal = cluster.LastWal
+		}
+	}
+
+	if primary != nil {
+		c.PrimaryCluster.Addr = primary.Addr
+		err := c.applyClusterConfig(&c.PrimaryCluster, *primary, "primary")
+		if err != nil {
+			return err
+		}
+	}
+	for i, snapshot := range others {
+		if i == 0 {
+			c.SecondaryCluster.Addr = snapshot.Addr
+			err := c.applyClusterConfig(&c.SecondaryCluster, snapshot, "secondary")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		cluster := ClusterData{Addr: snapshot.Addr}
+		err := c.applyClusterConfig(&cluster, snapshot, "")
+		if err != nil {
+			return err
+		}
+		c.AdditionalSecondaries = append(c.AdditionalSecondaries, cluster)
+	}
+
+	return nil
+}
+
+// Decode the replication status of a discovered cluster and derive its
+// replication role flags. The decoded status is only retained as the primary or
+// secondary configuration when the cluster's replication mode matches the role
+// it has been assigned, so that a conflicting or additional cluster does not
+// overwrite it. An empty role never retains the decoded status.
+func (c *ConfigData) applyClusterConfig(cluster *ClusterData, snapshot ClusterSnapshot, role string) error {
+	retain := snapshot.ReplicationMode == role
+	cluster.TokenValid = snapshot.TokenValid
+	cluster.StaleHeartbeat = hasIssue(c.healthIssues(snapshot), healthStaleHeartbeat)
+
+	switch c.ClientConfig.Mode {
+	case "dr":
+		switch snapshot.ReplicationMode {
+		case "primary":
+			var config PrimaryDrConfig
+			err := json.Unmarshal(snapshot.Replication, &config)
+			if err != nil {
+				return err
+			}
+			if config.Mode == "primary" && config.State == "running" {
+				cluster.Leader = true
+			}
+			if retain {
+				c.PrimaryDrConfig = config
+			}
+		case "secondary":
+			var config SecondaryDrConfig
+			err := json.Unmarshal(snapshot.Replication, &config)
+			if err != nil {
+				return err
+			}
+			if config.Mode == "secondary" {
+				cluster.Follower = true
+				cluster.SecondaryID = config.SecondaryID
+				if config.State == "stream-wals" {
+					for primary := range config.Primaries {
+						if config.Primaries[primary].ConnectionStatus == "connected" {
+							cluster.Connected = true
+							break
 						}
 					}
+				} else {
+					cluster.Connected = false
 				}
 			}
-		case "performance":
-			if repMode != "disabled" {
-				switch repMode {
-				case "primary":
-					if c.PrimaryCluster.Addr != "" {
-						log.Println("Multiple primary clusters detected - attempting to resolve conflict")
-						err = c.resolvePrimaryConflict(haveHighestWal, addr, client)
-						if err != nil {
-							return err
-						}
-					}
-					c.PrimaryCluster.Addr = addr
-					data, _ := json.Marshal(data["data"])
-					err = json.Unmarshal(data, &c.PrimaryPrConfig)
-					if err != nil {
-						return err
-					}
-					if c.PrimaryPrConfig.Mode == "primary" && c.PrimaryPrConfig.State == "running" {
-						c.PrimaryCluster.Leader = true
-					}
-				case "secondary":
-					if c.SecondaryCluster.Addr != "" {
-						log.Println("Multiple secondary clusters detected - attempting to resolve conflict")
-						err = c.resolveSecondaryConflict(haveHighestWal, addr, client)
-						if err != nil {
-							return err
-						}
-					}
-					c.SecondaryCluster.Addr = addr
-					data, _ := json.Marshal(data["data"])
-					err = json.Unmarshal(data, &c.SecondaryPrConfig)
-					if err != nil {
-						return err
-					}
-					if c.SecondaryPrConfig.Mode == "secondary" {
-						c.SecondaryCluster.Follower = true
-						if c.SecondaryPrConfig.State == "stream-wals" {
-							for primary := range c.SecondaryPrConfig.Primaries {
-								if c.SecondaryPrConfig.Primaries[primary].ConnectionStatus == "connected" {
-									c.SecondaryCluster.Connected = true
-									break
-								}
-							}
-						} else {
-							c.SecondaryCluster.Connected = false
+			if retain {
+				c.SecondaryDrConfig = config
+			}
+		}
+	case "performance":
+		switch snapshot.ReplicationMode {
+		case "primary":
+			var config PrimaryPrConfig
+			err := json.Unmarshal(snapshot.Replication, &config)
+			if err != nil {
+				return err
+			}
+			if config.Mode == "primary" && config.State == "running" {
+				cluster.Leader = true
+			}
+			if retain {
+				c.PrimaryPrConfig = config
+			}
+		case "secondary":
+			var config SecondaryPrConfig
+			err := json.Unmarshal(snapshot.Replication, &config)
+			if err != nil {
+				return err
+			}
+			if config.Mode == "secondary" {
+				cluster.Follower = true
+				cluster.SecondaryID = config.SecondaryID
+				if config.State == "stream-wals" {
+					for primary := range config.Primaries {
+						if config.Primaries[primary].ConnectionStatus == "connected" {
+							cluster.Connected = true
+							break
 						}
 					}
+				} else {
+					cluster.Connected = false
 				}
 			}
+			if retain {
+				c.SecondaryPrConfig = config
+			}
 		}
 	}
 
-	log.Println("Topology discovery complete")
 	return nil
 }
+
+// Return the number of WALs by which the secondary cluster trails the primary,
+// comparing the WAL last shipped by the primary for the replication mode with
+// the secondary's last_remote_wal. The second return value is false when the
+// replication status of either cluster is unavailable.
+func (c *ConfigData) walDelta() (int, bool) {
+	var primaryWal, remoteWal int
+	switch c.ClientConfig.Mode {
+	case "dr":
+		if c.PrimaryDrConfig.ClusterID == "" || c.SecondaryDrConfig.ClusterID == "" {
+			return 0, false
+		}
+		primaryWal, remoteWal = c.PrimaryDrConfig.LastDrWal, c.SecondaryDrConfig.LastRemoteWal
+		if primaryWal == 0 {
+			primaryWal = c.PrimaryDrConfig.LastWal
+		}
+	case "performance":
+		if c.PrimaryPrConfig.ClusterID == "" || c.SecondaryPrConfig.ClusterID == "" {
+			return 0, false
+		}
+		primaryWal, remoteWal = c.PrimaryPrConfig.LastPerformanceWal, c.SecondaryPrConfig.LastRemoteWal
+		if primaryWal == 0 {
+			primaryWal = c.PrimaryPrConfig.LastWal
+		}
+	default:
+		return 0, false
+	}
+	return primaryWal - remoteWal, true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRankSecondaries(t *testing.T) {
+	secondary := func(addr string, sealed bool, state string, connected bool, remoteWal int) ClusterSnapshot {
+		status := replicationStatus{LastRemoteWal: remoteWal}
+		if connected {
+			status.Primaries = []replicationPeer{{ConnectionStatus: "connected"}}
+		} else {
+			status.Primaries = []replicationPeer{{ConnectionStatus: "disconnected"}}
+		}
+		b, _ := json.Marshal(status)
+		return ClusterSnapshot{Addr: addr, Initialized: true, Sealed: sealed, ReplicationState: state, Replication: b}
+	}
+
+	tests := []struct {
+		name        string
+		secondaries []ClusterSnapshot
+		want        []string
+	}{
+		{
+			name: "highest remote WAL first",
+			secondaries: []ClusterSnapshot{
+				secondary("https://a:8200", false, "stream-wals", true, 10),
+				secondary("https://b:8200", false, "stream-wals", true, 20),
+			},
+			want: []string{"https://b:8200", "https://a:8200"},
+		},
+		{
+			name: "connected before a higher WAL",
+			secondaries: []ClusterSnapshot{
+				secondary("https://a:8200", false, "idle", false, 30),
+				secondary("https://b:8200", false, "stream-wals", true, 20),
+			},
+			want: []string{"https://b:8200", "https://a:8200"},
+		},
+		{
+			name: "connected status only counts while streaming WALs",
+			secondaries: []ClusterSnapshot{
+				secondary("https://a:8200", false, "merkle-sync", true, 10),
+				secondary("https://b:8200", false, "idle", false, 20),
+			},
+			want: []string{"https://b:8200", "https://a:8200"},
+		},
+		{
+			name: "healthy before connected",
+			secondaries: []ClusterSnapshot{
+				secondary("https://a:8200", true, "stream-wals", true, 30),
+				secondary("https://b:8200", false, "idle", false, 10),
+			},
+			want: []string{"https://b:8200", "https://a:8200"},
+		},
+		{
+			name: "ties keep their order",
+			secondaries: []ClusterSnapshot{
+				secondary("https://a:8200", false, "stream-wals", true, 10),
+				secondary("https://b:8200", false, "stream-wals", true, 10),
+			},
+			want: []string{"https://a:8200", "https://b:8200"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, snapshot := range rankSecondaries(tt.secondaries) {
+				got = append(got, snapshot.Addr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankSecondaries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmDrOperationTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(vaultTokenHeader) != "batch-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"type": "batch", "ttl": 3600}}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		token     string
+		primary   bool
+		wantValid bool
+		wantCheck string
+	}{
+		{
+			name:      "confirmed against the DR primary",
+			token:     "batch-token",
+			primary:   true,
+			wantValid: true,
+			wantCheck: tokenDrOperation,
+		},
+		{
+			name:      "rejected by the DR primary",
+			token:     "other-token",
+			primary:   true,
+			wantCheck: tokenUnverified,
+		},
+		{
+			name:      "supplied without a reachable DR primary",
+			token:     "other-token",
+			wantValid: true,
+			wantCheck: tokenDrSupplied,
+		},
+		{
+			name:      "not supplied without a reachable DR primary",
+			wantCheck: tokenUnverified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := Topology{
+				Mode:     "dr",
+				Clusters: []ClusterSnapshot{{Addr: "https://b:8200", ReplicationMode: "secondary", TokenCheck: tokenUnverified}},
+			}
+			if tt.primary {
+				topology.Clusters = append(topology.Clusters, ClusterSnapshot{Addr: srv.URL, ReplicationMode: "primary"})
+			}
+			c := ConfigData{ClientConfig: ClientConfig{Mode: "dr", OpBatchToken: tt.token}}
+			c.confirmDrOperationTokens(&topology, srv.Client())
+
+			got := topology.Clusters[0]
+			if got.TokenValid != tt.wantValid || got.TokenCheck != tt.wantCheck {
+				t.Errorf("secondary token = valid %t, check %s; want valid %t, check %s", got.TokenValid, got.TokenCheck, tt.wantValid, tt.wantCheck)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -10,16 +11,19 @@ import (
 	"strings"
 )
 
-// Verify that the provided addresses are valid and reachable
-func (c *ClientConfig) verifyAddrs() {
+// Verify that the provided addresses are valid and reachable. Unreachable
+// addresses are logged and left out of the verified addresses; invalid
+// addresses are an error.
+func (c *ClientConfig) verifyAddrs() error {
+	c.VerifiedAddrs = nil
 	addrs := strings.Split(c.ConfiguredAddrs, ",")
-	if len(addrs) != 2 {
-		log.Fatalf("Invalid number of addresses specified. Please provide exactly two addresses separated by a comma in `--addresses` flag\n")
+	if len(addrs) < 2 {
+		return fmt.Errorf("invalid number of addresses specified - provide at least two addresses separated by a comma in the -addresses flag")
 	}
 	for _, addr := range addrs {
 		_, err := url.ParseRequestURI(addr)
 		if err != nil {
-			log.Fatalf("invalid address: %s\n", addr)
+			return fmt.Errorf("invalid address: %s", addr)
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
@@ -35,7 +39,7 @@ func (c *ClientConfig) verifyAddrs() {
 
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/sys/health", nil)
 		if err != nil {
-			log.Fatalf("cannot create request: %s\n", err)
+			return fmt.Errorf("cannot create request: %w", err)
 		}
 		resp, err := client.Do(req)
 		if resp != nil {
@@ -49,4 +53,5 @@ func (c *ClientConfig) verifyAddrs() {
 			log.Printf("WARN: request errored for %s: %v\n", addr, err)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Tracks the scenario observed across successive polls of the topology
+type watcher struct {
+	config      ConfigData
+	interval    time.Duration
+	stablePolls int
+	metricsAddr string
+	// alert when the operation token expires within this window
+	tokenExpiryAlert time.Duration
+	expiryAlerted    bool
+	// rotate the stored operation token on this interval, if set
+	rotateInterval time.Duration
+	rotateTTL      string
+	lastRotation   time.Time
+	lastState      string
+	lastName       string
+	polls          int
+	acted          bool
+}
+
+// Describe the discovered topology and matched scenario, such that any change
+// between polls is treated as a state transition
+func (c *ConfigData) stateKey(s scenario) string {
+	key := fmt.Sprintf("%s primary=%s/%t/%t/%t",
+		s.Name,
+		c.PrimaryCluster.Addr, c.PrimaryCluster.Healthy, c.PrimaryCluster.Leader, c.PrimaryCluster.TokenValid)
+	for _, secondary := range c.secondaries() {
+		key += fmt.Sprintf(" secondary=%s/%t/%t/%t/%t/%t", secondary.Addr, secondary.Healthy, secondary.Follower, secondary.Connected, secondary.StaleHeartbeat, secondary.TokenValid)
+	}
+	return key
+}
+
+// Discover the topology once, record any state transition, and act on the
+// matched scenario if it is automatic and has been stable for enough polls
+func (w *watcher) poll() {
+	c := w.config.fresh()
+	err := c.refresh()
+	if err != nil {
+		log.Printf("WARN: discovery failed: %v", err)
+		operatorMetrics.observeError()
+		w.lastState, w.lastName, w.polls, w.acted = "", "unavailable", 0, false
+		return
+	}
+
+	s := c.selectScenario()
+	operatorMetrics.observe(&c, s)
+	w.checkTokenExpiry(&c)
+	w.rotateToken(&c)
+	state := c.stateKey(s)
+	if state != w.lastState {
+		if w.lastName != "" {
+			log.Printf("State transition: %s -> %s", w.lastName, s.Name)
+		}
+		log.Println(s.Description)
+		w.lastState, w.lastName, w.polls, w.acted = state, s.Name, 0, false
+	}
+	w.polls++
+
+	if !s.Automatic || s.Fatal || len(s.Steps) == 0 || w.acted || w.polls < w.stablePolls {
+		return
+	}
+
+	log.Printf("Scenario %s observed for %d consecutive polls - taking action", s.Name, w.polls)
+	if s.Warning != "" {
+		log.Println("WARNING:", s.Warning)
+	}
+	w.acted = true
+	err = c.executeScenario(s)
+	if err != nil {
+		log.Printf("ERROR: action for scenario %s failed: %v", s.Name, err)
+		w.polls, w.acted = 0, false
+		return
+	}
+	c.logCompletion()
+}
+
+// Rotate the stored operation token once the rotation interval has elapsed.
+// A failed rotation is retried on the next poll.
+func (w *watcher) rotateToken(c *ConfigData) {
+	if w.rotateInterval <= 0 || time.Since(w.lastRotation) < w.rotateInterval {
+		return
+	}
+	if c.ClientConfig.DryRun {
+		log.Printf("Would rotate the operation batch token with a TTL of %s", w.rotateTTL)
+		w.lastRotation = time.Now()
+		return
+	}
+	err := c.rotateOpBatchToken(w.rotateTTL)
+	recordAction("token_rotation", err)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+	w.lastRotation = time.Now()
+}
+
+// Poll the topology on an interval until the context is cancelled
+func (w *watcher) run(ctx context.Context) {
+	log.Printf("Watching topology every %s", w.interval)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.poll()
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping watch")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Continuously watch the topology, acting on automatic scenarios without
+// prompting the operator
+func runWatch(args []string) {
+	w := watcher{}
+	fs := w.config.newFlagSet("watch")
+	fs.DurationVar(&w.interval, "interval", 30*time.Second, "Interval between topology discovery polls")
+	fs.IntVar(&w.stablePolls, "stablePolls", 2, "Number of consecutive polls a scenario must be observed before action is taken")
+	fs.StringVar(&w.metricsAddr, "metricsAddr", "", "Address on which to serve Prometheus metrics at /metrics (disabled if empty)")
+	fs.DurationVar(&w.tokenExpiryAlert, "tokenExpiryAlert", 72*time.Hour, "Log an alert when the operation token expires within this duration")
+	fs.DurationVar(&w.rotateInterval, "rotateInterval", 0, "Interval on which to rotate the stored operation batch token (disabled if 0); requires -opBatchTokenFromKV")
+	fs.StringVar(&w.rotateTTL, "rotateTtl", "168h", "TTL of tokens minted by scheduled rotation")
+	w.config.privilegedTokenFlags(fs)
+	w.config.ClientConfig.Unattended = true
+	w.config.parseFlags(fs, args)
+
+	if w.interval <= 0 {
+		log.Fatalf("Invalid interval: %s\n", w.interval)
+	}
+	if w.stablePolls < 1 {
+		log.Fatalf("Invalid stablePolls: %d\n", w.stablePolls)
+	}
+	if w.rotateInterval > 0 && !w.config.ClientConfig.OpBatchTokenFromKV {
+		log.Fatalln("Scheduled token rotation requires -opBatchTokenFromKV, so that the rotated token is used")
+	}
+	// the first rotation happens one interval after startup
+	w.lastRotation = time.Now()
+
+	if w.metricsAddr != "" {
+		operatorMetrics.serve(w.metricsAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	w.run(ctx)
+}
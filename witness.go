@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The /v1/sys/health status codes of an initialized, unsealed node: active,
+// standby, DR secondary and performance standby
+var liveHealthCodes = map[int]bool{200: true, 429: true, 472: true, 473: true}
+
+// Whether a witness can reach a cluster's /v1/sys/health endpoint
+type clusterReachability struct {
+	Addr      string `json:"addr"`
+	Reachable bool   `json:"reachable"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// The response of a witness endpoint
+type witnessReport struct {
+	Witness  string                `json:"witness,omitempty"`
+	Clusters []clusterReachability `json:"clusters"`
+}
+
+// A witness's vote on whether the primary is down. Witnesses that cannot be
+// queried, or that do not report on every candidate primary, abstain.
+type witnessVote struct {
+	Witness     string `json:"witness"`
+	PrimaryDown bool   `json:"primaryDown"`
+	Abstained   bool   `json:"abstained,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+// Return the configured witness endpoints
+func (c *ClientConfig) witnesses() []string {
+	if c.Witness.Addrs == "" {
+		return nil
+	}
+	return strings.Split(c.Witness.Addrs, ",")
+}
+
+// Return the number of witnesses that must agree the primary is down, which
+// defaults to a majority of the configured witnesses
+func (c *ClientConfig) witnessQuorum() int {
+	if c.Witness.Quorum > 0 {
+		return c.Witness.Quorum
+	}
+	return len(c.witnesses())/2 + 1
+}
+
+// Report whether a cluster's /v1/sys/health endpoint can be reached from here
+// and reports an initialized, unsealed node
+func (c *ClientConfig) probeCluster(addr string) clusterReachability {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.TlsSkipVerify},
+			DialContext: (&net.Dialer{
+				Timeout: timeout,
+			}).DialContext,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/sys/health", nil)
+	if err != nil {
+		return clusterReachability{Addr: addr, Detail: err.Error()}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return clusterReachability{Addr: addr, Detail: err.Error()}
+	}
+	resp.Body.Close()
+	return clusterReachability{Addr: addr, Reachable: liveHealthCodes[resp.StatusCode], Detail: fmt.Sprintf("status %d", resp.StatusCode)}
+}
+
+// Build the HTTP client used to query witnesses. A client certificate is
+// presented to witnesses that require mutual TLS, and witness certificates are
+// verified against the witness CA file when one is given.
+func (c *ClientConfig) witnessClient() (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: c.TlsSkipVerify, MinVersion: tls.VersionTLS12}
+	if c.Witness.CertFile != "" || c.Witness.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.Witness.CertFile, c.Witness.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading witness client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.Witness.CAFile != "" {
+		pem, err := os.ReadFile(c.Witness.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading witness CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in witness CA file %s", c.Witness.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: timeout}, nil
+}
+
+// Return the addresses that may belong to the primary the target would replace:
+// any other discovered primary, or, when the operator reached none, every other
+// configured address it could not discover. When every configured cluster was
+// discovered as a secondary, these are the primaries the target last replicated
+// from. The target's own address is never a candidate.
+func (c *ConfigData) primaryCandidates(target *ClusterData) []string {
+	var candidates []string
+	discovered := map[string]bool{}
+	var targetStatus replicationStatus
+	for _, snapshot := range c.Topology.Clusters {
+		discovered[snapshot.Addr] = true
+		if snapshot.Addr == target.Addr {
+			json.Unmarshal(snapshot.Replication, &targetStatus)
+			continue
+		}
+		if snapshot.ReplicationMode == "primary" {
+			candidates = append(candidates, snapshot.Addr)
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates
+	}
+
+	for _, addr := range strings.Split(c.ClientConfig.ConfiguredAddrs, ",") {
+		if !discovered[addr] && addr != target.Addr {
+			candidates = append(candidates, addr)
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates
+	}
+
+	for _, peer := range targetStatus.Primaries {
+		if peer.APIAddress != "" && peer.APIAddress != target.Addr {
+			candidates = append(candidates, peer.APIAddress)
+		}
+	}
+	return candidates
+}
+
+// Ask a witness whether it can reach each of the given clusters
+func (c *ConfigData) queryWitness(client *http.Client, witness string, addrs []string) (witnessReport, error) {
+	var report witnessReport
+	query := url.Values{"addr": addrs}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(witness, "/")+"/v1/witness?"+query.Encode(), nil)
+	if err != nil {
+		return report, err
+	}
+	if c.ClientConfig.Witness.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ClientConfig.Witness.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("witness responded with status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return report, fmt.Errorf("error decoding witness response: %w", err)
+	}
+	return report, nil
+}
+
+// Collect the vote of each witness on whether the primary is down. A witness
+// votes the primary down when it can reach none of the candidate addresses.
+func (c *ConfigData) witnessVotes(client *http.Client, candidates []string) []witnessVote {
+	var votes []witnessVote
+	for _, witness := range c.ClientConfig.witnesses() {
+		vote := witnessVote{Witness: witness}
+		report, err := c.queryWitness(client, witness, candidates)
+		if err != nil {
+			vote.Abstained = true
+			vote.Detail = err.Error()
+			votes = append(votes, vote)
+			continue
+		}
+
+		reported := map[string]clusterReachability{}
+		for _, cluster := range report.Clusters {
+			reported[cluster.Addr] = cluster
+		}
+		vote.PrimaryDown = true
+		var details []string
+		for _, addr := range candidates {
+			cluster, ok := reported[addr]
+			switch {
+			case !ok:
+				vote.Abstained = true
+				details = append(details, addr+": not reported")
+			case cluster.Reachable:
+				vote.PrimaryDown = false
+				details = append(details, addr+": reachable")
+			default:
+				details = append(details, fmt.Sprintf("%s: unreachable (%s)", addr, cluster.Detail))
+			}
+		}
+		if vote.Abstained {
+			vote.PrimaryDown = false
+		}
+		vote.Detail = strings.Join(details, ", ")
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// Refuse to automatically promote the target unless a quorum of the configured
+// witnesses agrees that the primary is down. Each witness's vote is logged and
+// kept with the decision.
+func (c *ConfigData) witnessQuorumStep(target *ClusterData) step {
+	witnesses := c.ClientConfig.witnesses()
+	quorum := c.ClientConfig.witnessQuorum()
+	return step{
+		Description: fmt.Sprintf("Require %d of %d witnesses (%s) to agree that the primary is down before promoting %s", quorum, len(witnesses), strings.Join(witnesses, ", "), target.Addr),
+		run: func() error {
+			if quorum > len(witnesses) {
+				return fmt.Errorf("witness quorum of %d cannot be reached with %d witnesses", quorum, len(witnesses))
+			}
+			candidates := c.primaryCandidates(target)
+			if len(candidates) == 0 {
+				return fmt.Errorf("witness quorum: no candidate primary address to ask witnesses about")
+			}
+			client, err := c.ClientConfig.witnessClient()
+			if err != nil {
+				return fmt.Errorf("witness quorum: %w", err)
+			}
+
+			c.WitnessVotes = c.witnessVotes(client, candidates)
+			down := 0
+			for _, vote := range c.WitnessVotes {
+				switch {
+				case vote.Abstained:
+					log.Printf("Witness %s abstained: %s", vote.Witness, vote.Detail)
+				case vote.PrimaryDown:
+					down++
+					log.Printf("Witness %s voted primary down: %s", vote.Witness, vote.Detail)
+				default:
+					log.Printf("Witness %s voted primary up: %s", vote.Witness, vote.Detail)
+				}
+			}
+
+			if down < quorum {
+				err = fmt.Errorf("refusing to promote: only %d of %d witnesses agree that the primary is down (quorum %d)", down, len(witnesses), quorum)
+			} else {
+				log.Printf("%d of %d witnesses agree that the primary is down (quorum %d)", down, len(witnesses), quorum)
+			}
+			recordAction("witness_quorum", err)
+			return err
+		},
+	}
+}
+
+// Gate the steps of an automated promotion of the target on the witness quorum,
+// when witnesses are configured
+func (c *ConfigData) automatedPromotionSteps(target *ClusterData, steps []step) []step {
+	if len(c.ClientConfig.witnesses()) == 0 {
+		return steps
+	}
+	return append([]step{c.witnessQuorumStep(target)}, steps...)
+}
+
+// Handle GET /v1/witness by reporting whether each requested cluster can be
+// reached from this instance. Only configured addresses are probed; all of
+// them when none are requested.
+func (s *server) handleWitness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJson(w, http.StatusMethodNotAllowed, operationResult{Error: "method not allowed"})
+		return
+	}
+	configured := strings.Split(s.config.ClientConfig.ConfiguredAddrs, ",")
+	addrs := r.URL.Query()["addr"]
+	if len(addrs) == 0 {
+		addrs = configured
+	}
+
+	known := map[string]bool{}
+	for _, addr := range configured {
+		known[addr] = true
+	}
+	hostname, _ := os.Hostname()
+	report := witnessReport{Witness: hostname}
+	for _, addr := range addrs {
+		if !known[addr] {
+			writeJson(w, http.StatusBadRequest, operationResult{Error: "not a configured address: " + addr})
+			return
+		}
+		report.Clusters = append(report.Clusters, s.config.ClientConfig.probeCluster(addr))
+	}
+	writeJson(w, http.StatusOK, report)
+}
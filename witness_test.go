@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPrimaryCandidates(t *testing.T) {
+	status := func(primaries ...string) json.RawMessage {
+		s := replicationStatus{}
+		for _, addr := range primaries {
+			s.Primaries = append(s.Primaries, replicationPeer{APIAddress: addr})
+		}
+		b, _ := json.Marshal(s)
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		addrs    string
+		clusters []ClusterSnapshot
+		target   string
+		want     []string
+	}{
+		{
+			name:  "discovered primary",
+			addrs: "https://a:8200,https://b:8200",
+			clusters: []ClusterSnapshot{
+				{Addr: "https://a:8200", ReplicationMode: "primary", Replication: status()},
+				{Addr: "https://b:8200", ReplicationMode: "secondary", Replication: status("https://a:8200")},
+			},
+			target: "https://b:8200",
+			want:   []string{"https://a:8200"},
+		},
+		{
+			name:  "primary unreachable",
+			addrs: "https://a:8200,https://b:8200,https://c:8200",
+			clusters: []ClusterSnapshot{
+				{Addr: "https://b:8200", ReplicationMode: "secondary", Replication: status()},
+			},
+			target: "https://b:8200",
+			want:   []string{"https://a:8200", "https://c:8200"},
+		},
+		{
+			name:  "only secondaries, target excluded",
+			addrs: "https://a:8200,https://b:8200",
+			clusters: []ClusterSnapshot{
+				{Addr: "https://a:8200", ReplicationMode: "secondary", Replication: status("https://old:8200")},
+				{Addr: "https://b:8200", ReplicationMode: "secondary", Replication: status("https://old:8200")},
+			},
+			target: "https://a:8200",
+			want:   []string{"https://old:8200"},
+		},
+		{
+			name:  "only secondaries, no known primary",
+			addrs: "https://a:8200,https://b:8200",
+			clusters: []ClusterSnapshot{
+				{Addr: "https://a:8200", ReplicationMode: "secondary", Replication: status()},
+				{Addr: "https://b:8200", ReplicationMode: "secondary", Replication: status()},
+			},
+			target: "https://a:8200",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ConfigData{
+				ClientConfig: ClientConfig{ConfiguredAddrs: tt.addrs},
+				Topology:     Topology{Clusters: tt.clusters},
+			}
+			got := c.primaryCandidates(&ClusterData{Addr: tt.target})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("primaryCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}